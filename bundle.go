@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/flip"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// Prints the bundle of a group, or of everything, to paste elsewhere.
+func ExportCommand() flip.Command {
+	o := NewOptions()
+	fs := func(o *Options) *flip.FlagSet {
+		fs := flip.NewFlagSet("export", flip.ContinueOnError)
+		fs.StringVar(&o.pGroup, "group", o.pGroup, "The group of features to export, with the components and entities made of them. All features if empty.")
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"export",
+		"print a bundle string of a group of features, components and entities.",
+		2,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			d := newVector(o)
+			d.Set(data.NewStringItem("export_group", o.pGroup))
+			resp, point, err := exchange(Sonnect, "query", "export", d)
+			if err != nil {
+				return c, onError("query", "export", point, err)
+			}
+			sresp, err := unmarshal(resp)
+			if err != nil {
+				return c, onError("query", "export", "unmarshal", err)
+			}
+			if sresp.Error != "" {
+				return c, onError("query", "export", "result", errors.New(sresp.Error))
+			}
+			fmt.Println(sresp.Data.ToString("bundle"))
+			return c, flip.ExitSuccess
+		},
+		fs,
+	)
+}
+
+var NoBundleError = xrr.Xrror("provide a bundle string with -bundle or -stdin").Out
+
+func importVector(o *Options) (string, *data.Vector, error) {
+	bundles := splitNonEmpty(o.pBundle)
+	if o.pStdin {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", nil, err
+		}
+		bundles = append(bundles, strings.Fields(string(b))...)
+	}
+	if len(bundles) == 0 {
+		return "", nil, NoBundleError()
+	}
+	d := newVector(o)
+	d.Set(data.NewStringsItem("bundles", bundles...))
+	d.SetStrings("groups", o.pGroup)
+	d.SetString("load", o.pLoad)
+	return "populate_inline", d, nil
+}
+
+// Populates a server from bundle strings made by export.
+func ImportCommand() flip.Command {
+	o := NewOptions()
+	fs := func(o *Options) *flip.FlagSet {
+		fs := flip.NewFlagSet("import", flip.ContinueOnError)
+		fs.StringVar(&o.pBundle, "bundle", o.pBundle, "Comma separated string list of bundle strings to import.")
+		fs.BoolVar(&o.pStdin, "stdin", o.pStdin, "Read whitespace separated bundle strings from standard input.")
+		fs.StringVar(&o.pGroup, "group", o.pGroup, "Comma separated string list of set tags to apply to all features imported.")
+		fs.StringVar(&o.pLoad, "load", o.pLoad, "How anything already defined is loaded [error, skip, replace, merge-values].")
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"import",
+		"populate a countfloyd server from bundle strings made by export.",
+		1,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			action, d, err := importVector(o)
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitUsageError
+			}
+			return c, connect(Sonnect, "data", action, d)
+		},
+		fs,
+	)
+}
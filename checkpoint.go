@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/flip"
+)
+
+type cpOptions struct {
+	cpCreate, cpRollback string
+	cpDiff, cpTo         string
+}
+
+func checkpointVector(o *cpOptions) (string, *data.Vector, error) {
+	d := newVector(NewOptions())
+	var action string
+	var set []string
+	if o.cpCreate != "" {
+		action = "checkpoint"
+		set = append(set, action)
+		d.Set(data.NewStringItem("checkpoint_name", o.cpCreate))
+	}
+	if o.cpRollback != "" {
+		action = "rollback"
+		set = append(set, action)
+		d.Set(data.NewStringItem("checkpoint_name", o.cpRollback))
+	}
+	if o.cpDiff != "" {
+		action = "diff"
+		set = append(set, action)
+		d.Set(data.NewStringItem("diff_from", o.cpDiff), data.NewStringItem("diff_to", o.cpTo))
+	}
+	switch len(set) {
+	case 0:
+		return "list_checkpoints", d, nil
+	case 1:
+		return action, d, nil
+	}
+	return "", nil, MoreThanAllowableError(set)
+}
+
+// Checkpoints, rolls back, compares or lists the definitions loaded in a
+// server environment.
+func CheckpointCommand() flip.Command {
+	o := &cpOptions{cpTo: "current"}
+	fs := func(o *cpOptions) *flip.FlagSet {
+		fs := flip.NewFlagSet("checkpoint", flip.ContinueOnError)
+		fs.StringVar(&o.cpCreate, "create", o.cpCreate, "Checkpoint the environment as it is with this name.")
+		fs.StringVar(&o.cpRollback, "rollback", o.cpRollback, "Roll the environment back to the checkpoint with this name.")
+		fs.StringVar(&o.cpDiff, "diff", o.cpDiff, "Report the tags and values added, removed and changed from this checkpoint to the -to checkpoint.")
+		fs.StringVar(&o.cpTo, "to", o.cpTo, "The checkpoint -diff compares against, by default the environment as it currently is.")
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"checkpoint",
+		"checkpoint, roll back, diff or, with no flags, list checkpoints of a server environment",
+		3,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			action, d, err := checkpointVector(o)
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitUsageError
+			}
+			return c, connect(Sonnect, "system", action, d)
+		},
+		fs,
+	)
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/server"
 	"github.com/Laughs-In-Flowers/flip"
@@ -12,9 +16,21 @@ import (
 type Options struct {
 	LogFormatter                  string
 	Socket                        string
+	PidFile                       string
 	PGroups                       string
 	Pfeature, Pcomponent, Pentity string
 	PPcomponent, PPfeature        string
+	Timeout                       time.Duration
+	MetricsAddr                   string
+	AuditLog                      string
+	Watch                         bool
+	WatchInterval                 time.Duration
+	SocketMode, SocketGroup       string
+	AllowUsers, AllowGroups       string
+	Token, TokenFile              string
+	TokenServices                 string
+	Restore, Snapshot             string
+	SnapshotInterval              time.Duration
 }
 
 func unpackToStrings(f string) []string {
@@ -26,15 +42,50 @@ func unpackToStrings(f string) []string {
 	return ret
 }
 
+func unpackToInts(f string) ([]int, error) {
+	var ret []int
+	for _, v := range unpackToStrings(f) {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+// A server configuration failing with the provided error.
+func failed(err error) server.Config {
+	return server.DefaultConfig(func(*server.Server) error {
+		return err
+	})
+}
+
 func topFlags(o *Options) *flip.FlagSet {
 	fs := flip.NewFlagSet("", flip.ContinueOnError)
 	fs.StringVar(&o.LogFormatter, "logFormatter", o.LogFormatter, "Sets the environment logger formatter.")
 	fs.StringVar(&o.Socket, "socket", o.Socket, "Set the server socket path.")
+	fs.StringVar(&o.PidFile, "pidFile", o.PidFile, "Write the server process id to this file while serving.")
+	fs.DurationVar(&o.Timeout, "timeout", o.Timeout, "Set the server side limit for handling a single request.")
+	fs.StringVar(&o.MetricsAddr, "metrics", o.MetricsAddr, "Serve Prometheus format metrics over http at this address, e.g. localhost:9180.")
+	fs.StringVar(&o.AuditLog, "auditLog", o.AuditLog, "Append a JSON line recording every mutating request to this file.")
+	fs.StringVar(&o.SocketMode, "socketMode", o.SocketMode, "Set the octal file mode of the server socket, e.g. 0660.")
+	fs.StringVar(&o.SocketGroup, "socketGroup", o.SocketGroup, "Set the owning group of the server socket, by name or id.")
+	fs.StringVar(&o.AllowUsers, "allowUsers", o.AllowUsers, "Comma separated user ids allowed to make requests, checked by peer credentials.")
+	fs.StringVar(&o.AllowGroups, "allowGroups", o.AllowGroups, "Comma separated group ids allowed to make requests, checked by peer credentials.")
+	fs.StringVar(&o.Token, "token", o.Token, "Require this token for requests to restricted services.")
+	fs.StringVar(&o.TokenFile, "tokenFile", o.TokenFile, "Require the token read from this file for requests to restricted services.")
+	fs.StringVar(&o.TokenServices, "tokenServices", o.TokenServices, "Comma separated services restricted by any token.")
 	fs.StringVar(&o.Pfeature, "features", o.Pfeature, "Attempt to load features from specified files")
 	fs.StringVar(&o.Pcomponent, "components", o.Pcomponent, "Attempt to load components from specified files")
 	fs.StringVar(&o.Pentity, "entities", o.Pentity, "Attempt to load entities from specified files")
 	fs.StringVar(&o.PPcomponent, "componentPlugin", o.PPcomponent, "Attempt to load constructor plugin from dirs")
 	fs.StringVar(&o.PPfeature, "featurePlugin", o.PPfeature, "Attempt to load feature plugin from dirs")
+	fs.BoolVar(&o.Watch, "watch", o.Watch, "Reload -features, -components and -entities files whenever they change.")
+	fs.DurationVar(&o.WatchInterval, "watchInterval", o.WatchInterval, "Set the interval watched files are polled for changes.")
+	fs.StringVar(&o.Restore, "restore", o.Restore, "Restore every environment from this snapshot file before loading any other files.")
+	fs.StringVar(&o.Snapshot, "snapshot", o.Snapshot, "Write snapshots to this file, by default any -restore file.")
+	fs.DurationVar(&o.SnapshotInterval, "snapshotInterval", o.SnapshotInterval, "Write a snapshot at this interval while serving, and on shutdown.")
 	fs.StringVar(&o.PGroups, "groups", o.PGroups, "Groups parameter applied where features, components, or entities are populated.")
 	return fs
 }
@@ -56,19 +107,99 @@ var txx []tex = []tex{
 		if o.Socket != "" {
 			S.Add(server.SetSocketPath(o.Socket))
 		}
+		if o.SocketMode != "" {
+			m, err := strconv.ParseUint(o.SocketMode, 8, 32)
+			switch {
+			case err != nil:
+				S.Add(failed(err))
+			default:
+				S.Add(server.SetSocketMode(os.FileMode(m)))
+			}
+		}
+		if o.SocketGroup != "" {
+			S.Add(server.SetSocketGroup(o.SocketGroup))
+		}
+		if o.PidFile != "" {
+			S.Add(server.SetPidFile(o.PidFile))
+		}
+		if o.Timeout != 0 {
+			S.Add(server.SetTimeout(o.Timeout))
+		}
+		if o.MetricsAddr != "" {
+			S.Add(server.SetMetricsAddr(o.MetricsAddr))
+		}
+		if o.AuditLog != "" {
+			S.Add(server.SetAuditLog(o.AuditLog))
+		}
+	},
+	func(o *Options) {
+		if o.AllowUsers != "" {
+			uids, err := unpackToInts(o.AllowUsers)
+			switch {
+			case err != nil:
+				S.Add(failed(err))
+			default:
+				S.Add(server.SetAllowUid(uids...))
+			}
+		}
+		if o.AllowGroups != "" {
+			gids, err := unpackToInts(o.AllowGroups)
+			switch {
+			case err != nil:
+				S.Add(failed(err))
+			default:
+				S.Add(server.SetAllowGid(gids...))
+			}
+		}
+		token := o.Token
+		if o.TokenFile != "" {
+			b, err := ioutil.ReadFile(o.TokenFile)
+			if err != nil {
+				S.Add(failed(err))
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		if token != "" {
+			S.Add(server.SetServiceToken(token, unpackToStrings(o.TokenServices)...))
+		}
+	},
+	func(o *Options) {
+		if o.Snapshot != "" {
+			S.Add(server.SetSnapshotFile(o.Snapshot))
+		}
+		if o.SnapshotInterval != 0 {
+			S.Add(server.SetSnapshotInterval(o.SnapshotInterval))
+		}
+		if o.Restore != "" {
+			S.Add(server.SetRestore(o.Restore))
+		}
 	},
 	func(o *Options) {
-		if o.Pfeature != "" {
+		if o.WatchInterval != 0 {
+			S.Add(server.SetWatchInterval(o.WatchInterval))
+		}
+		if o.Watch {
+			for _, w := range []struct{ kind, files string }{
+				{"features", o.Pfeature},
+				{"components", o.Pcomponent},
+				{"entities", o.Pentity},
+			} {
+				if w.files != "" {
+					S.Add(server.SetWatch(w.kind, unpackToStrings(o.PGroups), unpackToStrings(w.files)...))
+				}
+			}
+		}
+		if o.Pfeature != "" && !o.Watch {
 			S.Add(server.SetPopulateFeatures(
 				unpackToStrings(o.PGroups),
 				unpackToStrings(o.Pfeature)...))
 		}
-		if o.Pcomponent != "" {
+		if o.Pcomponent != "" && !o.Watch {
 			S.Add(server.SetPopulateComponents(
 				unpackToStrings(o.PGroups),
 				unpackToStrings(o.Pcomponent)...))
 		}
-		if o.Pentity != "" {
+		if o.Pentity != "" && !o.Watch {
 			S.Add(server.SetPopulateEntities(
 				unpackToStrings(o.PGroups),
 				unpackToStrings(o.Pentity)...))
@@ -86,7 +217,7 @@ var txx []tex = []tex{
 }
 
 func TopCommand() flip.Command {
-	o := &Options{}
+	o := &Options{TokenServices: "data,system"}
 	fs := topFlags(o)
 	return flip.NewCommand(
 		"",
@@ -119,7 +250,10 @@ func StartCommand() flip.Command {
 				return c, flip.ExitFailure
 			}
 
-			S.Serve()
+			if err := S.Serve(); err != nil {
+				L.Printf("serving error: %s", err.Error())
+				return c, flip.ExitFailure
+			}
 
 			return c, flip.ExitSuccess
 		},
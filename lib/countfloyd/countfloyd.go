@@ -0,0 +1,357 @@
+// Package countfloyd embeds feature generation in a Go program, without a
+// server. A Generator loads feature, component and entity definitions, then
+// generates instances of them:
+//
+//	g, err := countfloyd.New()
+//	err = g.LoadFiles(ctx, countfloyd.Features, "features.yaml")
+//	err = g.LoadFiles(ctx, countfloyd.Components, "components.yaml")
+//	c, err := g.GenerateComponent(ctx, "face", countfloyd.Seed(42))
+//	eyes := c.String("EYES")
+//
+// The built in constructors are always available.
+package countfloyd
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature/constructors_common"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The kinds of definition a Generator loads.
+type Kind int
+
+const (
+	Features Kind = iota
+	Components
+	Entities
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Features:
+		return "features"
+	case Components:
+		return "components"
+	case Entities:
+		return "entities"
+	}
+	return "unknown"
+}
+
+var UnknownKindError = xrr.Xrror("unknown kind of definition: %d").Out
+
+// Generates features, components and entities from the definitions loaded
+// into it. A Generator is safe for concurrent use.
+type Generator struct {
+	mu sync.RWMutex
+	e  env.Env
+}
+
+// Returns a new Generator with an Env configured by the provided Config, e.g.
+// env.SetConstructorPlugin.
+func New(cnf ...env.Config) (*Generator, error) {
+	e, err := env.New(cnf...)
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{e: e}, nil
+}
+
+// The Env definitions are loaded into, for anything the Generator does not
+// provide.
+func (g *Generator) Env() env.Env {
+	return g.e
+}
+
+// Registers constructors for the definitions loaded after. A tag already
+// registered is an error, as is the tag of a built in constructor unless the
+// constructor is wrapped with feature.Override.
+func (g *Generator) Register(cs ...feature.Constructor) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.e.SetConstructor(cs...)
+}
+
+// Loads definitions of the provided kind from yaml files, returning every
+// error.
+func (g *Generator) LoadFiles(ctx context.Context, k Kind, files ...string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch k {
+	case Features:
+		return g.e.PopulateFeatureYaml(ctx, nil, files...)
+	case Components:
+		return g.e.PopulateComponentYaml(ctx, nil, files...)
+	case Entities:
+		return g.e.PopulateEntityYaml(ctx, nil, files...)
+	}
+	return UnknownKindError(k)
+}
+
+// Loads definitions of the provided kind from yaml, returning every error.
+func (g *Generator) LoadBytes(ctx context.Context, k Kind, b []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var errs feature.Errors
+	switch k {
+	case Features:
+		return g.e.Populate(ctx, b)
+	case Components:
+		rcs, err := feature.ParseComponents("", b)
+		if err != nil {
+			return err
+		}
+		errs.Add(feature.DeqComponent(ctx, g.e, rcs))
+	case Entities:
+		res, err := feature.ParseEntities("", b)
+		if err != nil {
+			return err
+		}
+		errs.Add(feature.DeqEntity(ctx, g.e, res))
+	default:
+		return UnknownKindError(k)
+	}
+	errs.Add(g.e.Dequeue(ctx))
+	return errs.Err()
+}
+
+// Loads definitions of the provided kind from yaml read from r.
+func (g *Generator) LoadReader(ctx context.Context, k Kind, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return g.LoadBytes(ctx, k, b)
+}
+
+type options struct {
+	seed     int64
+	seeded   bool
+	priority float64
+	session  string
+}
+
+// An option for generating.
+type Option func(*options)
+
+// Seeds the built in constructors, so that generating the same definitions
+// with the same seed generates the same values. Constructors from plugins
+// may use their own randomness.
+func Seed(n int64) Option {
+	return func(o *options) {
+		o.seed, o.seeded = n, true
+	}
+}
+
+// Sets the priority, a number for nonspecific use by features, as meta.priority.
+func Priority(n float64) Option {
+	return func(o *options) {
+		o.priority = n
+	}
+}
+
+// Sets the session identifying what is generated together, by default none
+// for features and components and a new random identifier for each entity.
+func Session(id string) Option {
+	return func(o *options) {
+		o.session = id
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, fn := range opts {
+		fn(o)
+	}
+	return o
+}
+
+// Generating draws on randomness shared by every Generator, so is serialized
+// to keep seeded generation reproducible.
+var generating sync.Mutex
+
+func (g *Generator) generate(o *options, fn func() error) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	generating.Lock()
+	defer generating.Unlock()
+	if o.seeded {
+		constructors_common.Seed(o.seed)
+	}
+	return fn()
+}
+
+// Maps each feature in order, rather than concurrently as an Env applies them,
+// so seeded values do not depend on scheduling.
+func (g *Generator) mapFeatures(tags []string, d *data.Vector) error {
+	for _, t := range tags {
+		f := g.e.GetFeature(t)
+		if f == nil {
+			return feature.NotFoundError("feature", t)
+		}
+		f.Map(d)
+	}
+	return nil
+}
+
+func (g *Generator) component(tag string, o *options) (*Component, error) {
+	c := feature.FindComponent(g.e, tag)
+	if c == nil {
+		return nil, feature.NotFoundError("component", tag)
+	}
+	r := newResult(o, c.Features())
+	if err := g.mapFeatures(c.Features(), r.v); err != nil {
+		return nil, err
+	}
+	r.v.SetString("component.tag", tag)
+	r.v.SetString("entity", o.session)
+	return &Component{tag, r}, nil
+}
+
+// Generates the provided features.
+func (g *Generator) GenerateFeature(ctx context.Context, tags []string, opts ...Option) (*Result, error) {
+	o := newOptions(opts)
+	var r *Result
+	err := g.generate(o, func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r = newResult(o, tags)
+		return g.mapFeatures(tags, r.v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Generates the features of the provided component.
+func (g *Generator) GenerateComponent(ctx context.Context, tag string, opts ...Option) (*Component, error) {
+	o := newOptions(opts)
+	var c *Component
+	err := g.generate(o, func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		c, err = g.component(tag, o)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Generates each component of the provided entity within one session.
+func (g *Generator) GenerateEntity(ctx context.Context, tag string, opts ...Option) (*Entity, error) {
+	o := newOptions(opts)
+	if o.session == "" {
+		o.session = feature.NewID()
+	}
+	var en *Entity
+	err := g.generate(o, func() error {
+		e := feature.FindEntity(g.e, tag)
+		if e == nil {
+			return feature.NotFoundError("entity", tag)
+		}
+		en = &Entity{Tag: tag, Session: o.session}
+		for _, ct := range e.Components() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			c, err := g.component(ct, o)
+			if err != nil {
+				return err
+			}
+			en.Components = append(en.Components, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return en, nil
+}
+
+// Generated features, with their values by tag.
+type Result struct {
+	Priority float64
+	Session  string
+	Tags     []string
+	v        *data.Vector
+}
+
+func newResult(o *options, tags []string) *Result {
+	return &Result{
+		Priority: o.priority,
+		Session:  o.session,
+		Tags:     tags,
+		v:        feature.NewData(o.priority),
+	}
+}
+
+func key(tag string) string {
+	return strings.ToUpper(tag)
+}
+
+// The value of a feature as a string.
+func (r *Result) String(tag string) string {
+	return r.v.ToString(key(tag))
+}
+
+// The value of a feature as a list of strings.
+func (r *Result) Strings(tag string) []string {
+	return r.v.ToStrings(key(tag))
+}
+
+// The value of a feature as an int.
+func (r *Result) Int(tag string) int {
+	return r.v.ToInt(key(tag))
+}
+
+// The value of a feature as a float64.
+func (r *Result) Float64(tag string) float64 {
+	return r.v.ToFloat64(key(tag))
+}
+
+// The value of a feature as a bool.
+func (r *Result) Bool(tag string) bool {
+	return r.v.ToBool(key(tag))
+}
+
+// The generated values as a data Vector, as an apply request returns them.
+func (r *Result) Vector() *data.Vector {
+	return r.v
+}
+
+// A generated component.
+type Component struct {
+	Tag string
+	*Result
+}
+
+// A generated entity, its components sharing a session.
+type Entity struct {
+	Tag        string
+	Session    string
+	Components []*Component
+}
+
+// The first generated component with the provided tag, if any.
+func (e *Entity) Component(tag string) (*Component, bool) {
+	for _, c := range e.Components {
+		if c.Tag == tag {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package countfloyd_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/countfloyd"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+)
+
+var (
+	features = `- tag: colour
+  apply: simple_random
+  values: [1, red, green, blue, yellow, black, white]
+- tag: size
+  apply: simple_random
+  values: [1, small, medium, large]
+`
+	components = `- tag: thing
+  features:
+  - tag: colour
+  - tag: size
+`
+	entities = `- tag: things
+  components:
+  - tag: thing
+`
+)
+
+func generator(t *testing.T) *countfloyd.Generator {
+	g, err := countfloyd.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := g.LoadBytes(ctx, countfloyd.Features, []byte(features)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadReader(ctx, countfloyd.Components, strings.NewReader(components)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadBytes(ctx, countfloyd.Entities, []byte(entities)); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGenerator(t *testing.T) {
+	g := generator(t)
+	ctx := context.Background()
+
+	r, err := g.GenerateFeature(ctx, []string{"colour"}, countfloyd.Priority(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.String("colour") == "" || r.Priority != 7 {
+		t.Errorf("unexpected feature result: %q, priority %v", r.String("colour"), r.Priority)
+	}
+
+	seeded := func() string {
+		c, err := g.GenerateComponent(ctx, "thing", countfloyd.Seed(42), countfloyd.Session("s"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Session != "s" {
+			t.Errorf("expected session s, have %q", c.Session)
+		}
+		return c.String("colour") + c.String("size")
+	}
+	if a, b := seeded(), seeded(); a != b {
+		t.Errorf("the same seed generated %s then %s", a, b)
+	}
+
+	en, err := g.GenerateEntity(ctx, "things")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := en.Component("thing"); !ok || c.Session != en.Session || en.Session == "" {
+		t.Errorf("expected the thing component within the entity session %q", en.Session)
+	}
+
+	if _, err := g.GenerateComponent(ctx, "nothing"); err == nil {
+		t.Error("generating an undefined component did not return an error")
+	}
+	if _, err := g.GenerateFeature(ctx, []string{"nothing"}); err == nil {
+		t.Error("generating an undefined feature did not return an error")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	g := generator(t)
+	c, ok := g.Env().GetConstructor("simple_random")
+	if !ok {
+		t.Fatal("no simple_random constructor")
+	}
+	if err := g.Register(c); err == nil {
+		t.Error("registering the tag of a built in constructor did not return an error")
+	}
+	if err := g.Register(feature.Override(c)); err != nil {
+		t.Errorf("registering an override of a built in constructor failed: %s", err)
+	}
+	if err := g.Register(feature.Override(c)); err == nil {
+		t.Error("registering a tag already registered did not return an error")
+	}
+}
@@ -1,6 +1,7 @@
 package env
 
 import (
+	"context"
 	"sort"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
@@ -116,13 +117,35 @@ var builtIns = []Config{}
 
 func SetPopulateFeature(groups []string, p []byte) Config {
 	return DefaultConfig(func(e *env) error {
-		if err := e.populateFeature(groups, p); err != nil {
+		if err := e.populateFeature(context.Background(), groups, "", p); err != nil {
 			return err
 		}
 		return nil
 	})
 }
 
+// Replaces the constructor registry of the env, by default layered over the
+// built in feature.Internal, e.g. to isolate an env with its own constructor set.
+func SetConstructorRegistry(c feature.Constructors) Config {
+	return NewConfig(1, func(e *env) error {
+		e.Constructors = c
+		return nil
+	})
+}
+
+// Sets constructors local to the env, shadowing any built in constructor of
+// the same tag.
+func SetConstructorOverrides(cs ...feature.Constructor) Config {
+	return DefaultConfig(func(e *env) error {
+		for _, c := range cs {
+			if err := e.SetConstructor(feature.Override(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func SetConstructors(cs ...feature.Constructor) Config {
 	return DefaultConfig(func(e *env) error {
 		if err := e.SetConstructor(cs...); err != nil {
@@ -134,7 +157,7 @@ func SetConstructors(cs ...feature.Constructor) Config {
 
 func SetConstructorPlugin(dirs ...string) Config {
 	return DefaultConfig(func(e *env) error {
-		if err := e.PopulateConstructorPlugin(dirs...); err != nil {
+		if err := e.PopulateConstructorPlugin(context.Background(), dirs...); err != nil {
 			return err
 		}
 		return nil
@@ -143,7 +166,7 @@ func SetConstructorPlugin(dirs ...string) Config {
 
 func SetFeaturePlugin(groups []string, dirs ...string) Config {
 	return DefaultConfig(func(e *env) error {
-		if err := e.PopulateFeaturePlugin(groups, dirs...); err != nil {
+		if err := e.PopulateFeaturePlugin(context.Background(), groups, dirs...); err != nil {
 			return err
 		}
 		return nil
@@ -0,0 +1,112 @@
+package env
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+)
+
+// A feature, component or entity differing between two Envs, described before
+// and after, either being empty for one added or removed.
+type Change struct {
+	Kind, Tag     string
+	Before, After string
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Before == "":
+		return fmt.Sprintf("%s %s: %s", c.Kind, c.Tag, c.After)
+	case c.After == "":
+		return fmt.Sprintf("%s %s: %s", c.Kind, c.Tag, c.Before)
+	}
+	return fmt.Sprintf("%s %s: %s -> %s", c.Kind, c.Tag, c.Before, c.After)
+}
+
+// The features, components and entities added, removed and changed between
+// two Envs.
+type Difference struct {
+	Added, Removed, Changed []Change
+}
+
+func list(l []string) string {
+	return "[" + strings.Join(l, ",") + "]"
+}
+
+// Describes the definition of every feature not derived by a constructor.
+func describeFeatures(e Env) map[string]string {
+	ret := make(map[string]string)
+	for _, rf := range e.ListAll() {
+		TAG := strings.ToUpper(rf.Tag)
+		def, ok := feature.DefinitionOf(e.GetFeature(TAG))
+		if !ok {
+			def = rf
+		}
+		if def.Source.Parent != "" {
+			continue
+		}
+		var groups []string
+		for _, g := range def.Group {
+			if g != "" {
+				groups = append(groups, g)
+			}
+		}
+		sort.Strings(groups)
+		ret[TAG] = fmt.Sprintf("%s %s groups %s", strings.ToUpper(def.Apply), list(def.Values), list(groups))
+	}
+	return ret
+}
+
+func describeComponents(e Env) map[string]string {
+	ret := make(map[string]string)
+	for _, c := range e.ListComponents() {
+		ret[c.Tag()] = fmt.Sprintf("defines %s features %s", list(c.Defines()), list(c.Features()))
+	}
+	return ret
+}
+
+func describeEntities(e Env) map[string]string {
+	ret := make(map[string]string)
+	for _, en := range e.ListEntities() {
+		ret[en.Tag()] = fmt.Sprintf("defines %s components %s", list(en.Defines()), list(en.Components()))
+	}
+	return ret
+}
+
+func (d *Difference) compare(kind string, a, b map[string]string) {
+	var tags []string
+	for t := range a {
+		tags = append(tags, t)
+	}
+	for t := range b {
+		if _, ok := a[t]; !ok {
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		before, had := a[t]
+		after, has := b[t]
+		switch {
+		case !had:
+			d.Added = append(d.Added, Change{kind, t, "", after})
+		case !has:
+			d.Removed = append(d.Removed, Change{kind, t, before, ""})
+		case before != after:
+			d.Changed = append(d.Changed, Change{kind, t, before, after})
+		}
+	}
+}
+
+// Returns how b differs from a: the features, components and entities added,
+// removed, or defined differently, by constructor, values or groups. Features
+// derived by a constructor follow their parent and are not compared.
+func Diff(a, b Env) *Difference {
+	d := &Difference{}
+	d.compare("feature", describeFeatures(a), describeFeatures(b))
+	d.compare("component", describeComponents(a), describeComponents(b))
+	d.compare("entity", describeEntities(a), describeEntities(b))
+	return d
+}
@@ -1,11 +1,10 @@
 package env
 
 import (
-	"io/ioutil"
+	"context"
+	"sort"
 	"sync"
 
-	yaml "gopkg.in/yaml.v2"
-
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
 	"github.com/Laughs-In-Flowers/data"
 )
@@ -23,17 +22,26 @@ type Env interface {
 
 type Applicator interface {
 	Apply([]string, *data.Vector, ...feature.MapFn) error
-	ApplyFor(int, []string, *data.Vector, ...feature.MapFn) error
+	ApplyFor(context.Context, int, []string, *data.Vector, ...feature.MapFn) error
 }
 
+// An interface for populating an Env. The provided context bounds any
+// construction work, which stops when the context is done, and may carry the
+// feature.LoadMode of anything defined again, see feature.WithLoadMode.
 type Populator interface {
-	Populate([]byte) error
-	PopulateConstructorPlugin(...string) error
-	PopulateFeaturePlugin([]string, ...string) error
-	PopulateFeatureYaml([]string, ...string) error
-	PopulateFeatureGroupString([]string, ...string) error
-	PopulateComponentYaml([]string, ...string) error
-	PopulateEntityYaml([]string, ...string) error
+	Populate(context.Context, []byte) error
+	PopulateConstructorPlugin(context.Context, ...string) error
+	PopulateFeaturePlugin(context.Context, []string, ...string) error
+	PopulateFeatureYaml(context.Context, []string, ...string) error
+	PopulateFeatureGroupString(context.Context, []string, ...string) error
+	PopulateComponentYaml(context.Context, []string, ...string) error
+	PopulateEntityYaml(context.Context, []string, ...string) error
+	PopulateFeatureBytes(context.Context, []string, string, []byte) error
+	PopulateComponentBytes(context.Context, []string, string, []byte) error
+	PopulateEntityBytes(context.Context, []string, string, []byte) error
+	PopulateBundle(context.Context, []string, ...string) error
+	PopulatePatchYaml(context.Context, []string, ...string) error
+	PopulatePatchBytes(context.Context, []string, string, []byte) error
 }
 
 type env struct {
@@ -43,6 +51,8 @@ type env struct {
 	feature.Features
 	feature.Components
 	feature.Entities
+	plugins []PluginLoad
+	patches patchLog
 }
 
 func Empty() Env {
@@ -53,10 +63,11 @@ func empty() *env {
 	e := &env{}
 	e.Raw = feature.NewRaw(e)
 	e.Loader, _ = NewPlugins()
-	e.Constructors = feature.Internal
+	e.Constructors = feature.LayerConstructors(feature.Internal)
 	e.Features = feature.NewFeatures(e)
 	e.Components = feature.NewComponents(e)
 	e.Entities = feature.NewEntities(e)
+	e.patches = newPatchLog()
 	return e
 }
 
@@ -69,11 +80,90 @@ func New(cnf ...Config) (Env, error) {
 	return e, nil
 }
 
-func (e *env) Populate(r []byte) error {
-	return e.populateFeature([]string{}, r)
+// Returns a new Env holding the constructors, templates, features, components
+// and entities of the provided Env, and the patches applied to it. Features are
+// reconstructed within the new Env so they do not refer back to the original,
+// keeping any state they hold.
+func Clone(ctx context.Context, from Env) (Env, error) {
+	to := empty()
+	switch fe := from.(type) {
+	case *env:
+		to.Constructors = feature.CopyConstructors(fe.Constructors)
+		to.plugins = append(to.plugins, fe.plugins...)
+		to.patches = fe.patches.copy()
+	default:
+		to.Constructors = feature.CopyConstructors(from)
+	}
+	order := func(rf *feature.RawFeature) int {
+		if c, ok := to.GetConstructor(rf.Apply); ok {
+			return c.Order()
+		}
+		if c, ok := to.GetConstructor("default"); ok {
+			return c.Order()
+		}
+		return 0
+	}
+
+	var templates []*feature.RawFeature
+	for _, t := range from.ListTemplates() {
+		nt := t
+		templates = append(templates, &nt)
+	}
+	if err := to.AddRaw(templates...); err != nil {
+		return nil, err
+	}
+
+	var rfs []*feature.RawFeature
+	for _, rf := range from.ListAll() {
+		nrf := rf
+		nrf.Constructor = nil
+		rfs = append(rfs, &nrf)
+	}
+	sort.SliceStable(rfs, func(i, j int) bool {
+		return order(rfs[i]) < order(rfs[j])
+	})
+
+	for _, rf := range rfs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// features derived by a constructor already exist in the new Env
+		if to.GetFeature(rf.Tag) != nil {
+			continue
+		}
+		// without a constructor, as with plugin features, share the original
+		if _, ok := to.GetConstructor(rf.Apply); !ok {
+			if f := from.GetFeature(rf.Tag); f != nil {
+				to.AddFeature(f)
+				continue
+			}
+		}
+		if err := to.SetFeature(rf.WithContext(ctx)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := copyState(from, to); err != nil {
+		return nil, err
+	}
+
+	if err := to.SetComponent(from.ListComponents()...); err != nil {
+		return nil, err
+	}
+	if err := to.SetEntity(from.ListEntities()...); err != nil {
+		return nil, err
+	}
+	return to, nil
+}
+
+func (e *env) Populate(ctx context.Context, r []byte) error {
+	return e.populateFeature(ctx, []string{}, "", r)
 }
 
-func (e *env) PopulateConstructorPlugin(dirs ...string) error {
+func (e *env) PopulateConstructorPlugin(ctx context.Context, dirs ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	aErr := e.Loader.AddDirs(dirs...)
 	if aErr != nil {
 		return aErr
@@ -85,10 +175,14 @@ func (e *env) PopulateConstructorPlugin(dirs ...string) error {
 	if sErr := e.Constructors.SetConstructor(nc...); sErr != nil {
 		return sErr
 	}
+	e.plugins = append(e.plugins, PluginLoad{Kind: ConstructorPlugin, Dirs: dirs})
 	return nil
 }
 
-func (e *env) PopulateFeaturePlugin(groups []string, dirs ...string) error {
+func (e *env) PopulateFeaturePlugin(ctx context.Context, groups []string, dirs ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	aErr := e.Loader.AddDirs(dirs...)
 	if aErr != nil {
 		return aErr
@@ -98,89 +192,155 @@ func (e *env) PopulateFeaturePlugin(groups []string, dirs ...string) error {
 		return fErr
 	}
 	e.AddFeature(nfs...)
+	e.plugins = append(e.plugins, PluginLoad{Kind: FeaturePlugin, Dirs: dirs, Groups: groups})
 	return nil
 }
 
-func (e *env) populateFeature(g []string, r []byte) error {
-	err := e.Queue(r)
+func (e *env) populateFeature(ctx context.Context, g []string, file string, r []byte) error {
+	r, err := feature.ExpandVars(ctx, file, r)
 	if err != nil {
 		return err
 	}
-	e.Dequeue(g...)
-	return nil
+	rfs, err := feature.ParseFeatures(file, r)
+	if err != nil {
+		return err
+	}
+	if err := e.AddRaw(rfs...); err != nil {
+		return err
+	}
+	return e.Dequeue(ctx, g...)
 }
 
-func (e *env) PopulateFeatureYaml(groups []string, files ...string) error {
-	for _, file := range files {
-		read, err := ioutil.ReadFile(file)
-		if err == nil {
-			err := e.populateFeature(groups, read)
-			if err != nil {
-				return err
-			}
-			continue
-		}
+// Populates features from each file, of any feature.Format, after the files
+// each includes, continuing past any file that cannot be read or parsed and
+// returning every error.
+func (e *env) PopulateFeatureYaml(ctx context.Context, groups []string, files ...string) error {
+	docs, err := readIncludes(files)
+	var errs feature.Errors
+	errs.Add(err)
+	for _, d := range docs {
+		errs.Add(e.populateFeature(ctx, groups, d.file, d.in))
 	}
-	return nil
+	return errs.Err()
 }
 
-func (e *env) PopulateFeatureGroupString(groups []string, sv ...string) error {
+func (e *env) PopulateFeatureGroupString(ctx context.Context, groups []string, sv ...string) error {
+	var errs feature.Errors
 	for _, s := range sv {
 		set, err := feature.DecodeFeatureGroup(s)
-		b, err := set.Bytes()
 		if err != nil {
-			return err
+			errs.Add(err)
+			continue
 		}
-		err = e.populateFeature(groups, b)
+		b, err := set.Bytes()
 		if err != nil {
-			return err
+			errs.Add(err)
+			continue
 		}
+		errs.Add(e.populateFeature(ctx, groups, "", b))
 	}
-	return nil
+	return errs.Err()
 }
 
-func (e *env) PopulateComponentYaml(groups []string, files ...string) error {
-	var err error
-	for _, file := range files {
-		var rcs []*feature.RawComponent
-		var read []byte
-		read, err = ioutil.ReadFile(file)
+// Populates the features, components and entities of each bundle string,
+// continuing past any that cannot be decoded and returning every error.
+func (e *env) PopulateBundle(ctx context.Context, groups []string, bs ...string) error {
+	var errs feature.Errors
+	for _, s := range bs {
+		b, err := feature.DecodeBundle(s)
 		if err != nil {
-			return err
+			errs.Add(err)
+			continue
 		}
-		err = yaml.Unmarshal(read, &rcs)
-		if err != nil {
-			return err
+		if err := e.AddRaw(b.Features...); err != nil {
+			errs.Add(err)
+			continue
 		}
-		err = feature.DeqComponent(e, rcs)
+		errs.Add(e.Dequeue(ctx, groups...))
+		errs.Add(feature.DeqComponent(ctx, e, b.Components))
+		errs.Add(feature.DeqEntity(ctx, e, b.Entities))
+		errs.Add(e.Dequeue(ctx, groups...))
 	}
-	e.Dequeue(groups...)
-	return err
+	return errs.Err()
 }
 
-func (e *env) PopulateEntityYaml(groups []string, files ...string) error {
-	var err error
-	for _, file := range files {
-		var res []*feature.RawEntity
-		var read []byte
-		read, err = ioutil.ReadFile(file)
-		if err != nil {
-			return err
-		}
-		err = yaml.Unmarshal(read, &res)
-		if err != nil {
-			return err
-		}
-		err = feature.DeqEntity(e, res)
+func (e *env) queueComponents(ctx context.Context, name string, in []byte) error {
+	in, err := feature.ExpandVars(ctx, name, in)
+	if err != nil {
+		return err
+	}
+	rcs, err := feature.ParseComponents(name, in)
+	if err != nil {
+		return err
+	}
+	return feature.DeqComponent(ctx, e, rcs)
+}
+
+func (e *env) queueEntities(ctx context.Context, name string, in []byte) error {
+	in, err := feature.ExpandVars(ctx, name, in)
+	if err != nil {
+		return err
 	}
-	e.Dequeue(groups...)
-	return err
+	res, err := feature.ParseEntities(name, in)
+	if err != nil {
+		return err
+	}
+	return feature.DeqEntity(ctx, e, res)
+}
+
+// Reads and queues each file, after the files each includes, continuing past
+// any that fails, then dequeues everything queued, returning every error.
+func (e *env) populateFiles(ctx context.Context, groups []string, files []string, queue func(context.Context, string, []byte) error) error {
+	docs, err := readIncludes(files)
+	var errs feature.Errors
+	errs.Add(err)
+	for _, d := range docs {
+		errs.Add(queue(ctx, d.file, d.in))
+	}
+	errs.Add(e.Dequeue(ctx, groups...))
+	return errs.Err()
+}
+
+// Populates components from each file, of any feature.Format, returning every
+// error.
+func (e *env) PopulateComponentYaml(ctx context.Context, groups []string, files ...string) error {
+	return e.populateFiles(ctx, groups, files, e.queueComponents)
+}
+
+// Populates entities from each file, of any feature.Format, returning every
+// error.
+func (e *env) PopulateEntityYaml(ctx context.Context, groups []string, files ...string) error {
+	return e.populateFiles(ctx, groups, files, e.queueEntities)
+}
+
+// Populates features from a document of any feature.Format, errors and sources
+// being located within the provided name, if any.
+func (e *env) PopulateFeatureBytes(ctx context.Context, groups []string, name string, in []byte) error {
+	return e.populateFeature(ctx, groups, name, in)
+}
+
+// Populates components from a document of any feature.Format, errors and sources
+// being located within the provided name, if any.
+func (e *env) PopulateComponentBytes(ctx context.Context, groups []string, name string, in []byte) error {
+	var errs feature.Errors
+	errs.Add(e.queueComponents(ctx, name, in))
+	errs.Add(e.Dequeue(ctx, groups...))
+	return errs.Err()
+}
+
+// Populates entities from a document of any feature.Format, errors and sources
+// being located within the provided name, if any.
+func (e *env) PopulateEntityBytes(ctx context.Context, groups []string, name string, in []byte) error {
+	var errs feature.Errors
+	errs.Add(e.queueEntities(ctx, name, in))
+	errs.Add(e.Dequeue(ctx, groups...))
+	return errs.Err()
 }
 
 // Apply the list of features to the provided data Vector, following up with
 // the provided MapFn. Internally is ApplyFor where n = 1.
 func (e *env) Apply(list []string, to *data.Vector, with ...feature.MapFn) error {
-	return e.ApplyFor(1, list, to, with...)
+	return e.ApplyFor(context.Background(), 1, list, to, with...)
 }
 
 func fill(e Env, list []string, to *data.Vector) {
@@ -199,9 +359,13 @@ func fill(e Env, list []string, to *data.Vector) {
 }
 
 // Apply for n number of passes the provided list of features to the provided data Vector,
-// following up with the provided MapFn.
-func (e *env) ApplyFor(pass int, list []string, to *data.Vector, with ...feature.MapFn) error {
+// following up with the provided MapFn. Stops between passes once the provided
+// context is done, returning its error.
+func (e *env) ApplyFor(ctx context.Context, pass int, list []string, to *data.Vector, with ...feature.MapFn) error {
 	for i := 1; i <= pass; i = i + 1 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		fill(e, list, to)
 		for _, fn := range with {
 			fn(to)
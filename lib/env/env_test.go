@@ -1,8 +1,10 @@
 package env_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -735,26 +737,37 @@ func testOfConstructor(t *testing.T, e feature.CEnv) {
 		t.Errorf("Constructor does not exist: %s", ck)
 	}
 
+	for _, c := range testConstructors {
+		if _, exists := e.GetConstructor(c.Tag()); !exists {
+			t.Errorf("Env constructor does not exist: %s", c.Tag())
+		}
+		if _, exists := feature.GetConstructor(c.Tag()); exists {
+			t.Errorf("Env constructor exists outside the env: %s", c.Tag())
+		}
+	}
+
 	cl1 := feature.ListConstructors()
 	cl2 := e.ListConstructors()
-	if len(cl1) != len(cl2) {
-		t.Error("expected equal constructor lists")
+	if len(cl1)+len(testConstructors) != len(cl2) {
+		t.Error("expected env constructors to be the built in and env constructors")
 	}
 	csl1 := constructorSort(cl1)
-	csl2 := constructorSort(cl2)
 	sort.Sort(csl1)
-	sort.Sort(csl2)
-	l := csl1.Len() - 1
-	for i := 0; i <= l; i++ {
-		t1, t2 := csl1[i].Tag(), csl2[i].Tag()
-		if t1 != t2 {
-			t.Errorf("Constructor tags should be the same but are not: %s - %s", t1, t2)
-		}
-		o1, o2 := csl1[i].Order(), csl1[i].Order()
+	for _, c1 := range csl1 {
+		c2, exists := e.GetConstructor(c1.Tag())
+		if !exists {
+			t.Errorf("Built in constructor does not exist in env: %s", c1.Tag())
+			continue
+		}
+		o1, o2 := c1.Order(), c2.Order()
 		if o1 != o2 {
 			t.Errorf("Constructor order should be the same but are not: %d - %d", o1, o2)
 		}
 	}
+
+	if err := e.SetConstructor(additionalConstructor); err == nil {
+		t.Error("Setting a constructor shadowing a built in constructor did not return an error.")
+	}
 }
 
 func testOfFeature(t *testing.T, e feature.CEnv) {
@@ -816,7 +829,7 @@ func testOfFeatureGroup(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	e.Populate(b)
+	e.Populate(context.Background(), b)
 
 	testOfFeature(t, e)
 
@@ -870,6 +883,24 @@ func testOfEntity(t *testing.T, e feature.CEnv) {
 	}
 }
 
+func testOfClone(t *testing.T, e env.Env) {
+	c, err := env.Clone(context.Background(), e)
+	errIf(t, err)
+
+	if have, expect := len(c.ListAll()), len(e.ListAll()); have != expect {
+		t.Errorf("cloned env has %d features, expected %d", have, expect)
+	}
+
+	testOfComponent(t, c)
+
+	testOfEntity(t, c)
+
+	errIf(t, c.Remove("SET"))
+	if len(c.List("SET")) != 0 || len(e.List("SET")) == 0 {
+		t.Error("removing a group from a cloned env did not leave the original env unchanged")
+	}
+}
+
 func errIf(t *testing.T, e error) {
 	if e != nil {
 		t.Error(e)
@@ -891,41 +922,41 @@ func testEnv(t *testing.T) feature.CEnv {
 	errIf(t, err)
 
 	var e env.Env
-	e, err = env.New()
+	e, err = env.New(env.SetConstructors(testConstructors...))
 	errIf(t, err)
 
-	errIf(t, e.Populate(b))
+	errIf(t, e.Populate(context.Background(), b))
 
 	errIf(t, writePlugin(plgnCLoc))
 
 	errIf(t, compilePlugin(plgnCLoc))
 
-	errIf(t, e.PopulateConstructorPlugin(plgnDir))
+	errIf(t, e.PopulateConstructorPlugin(context.Background(), plgnDir))
 
 	var cb []byte
 	cb, err = yaml.Marshal(&rawPluginConstructors)
-	errIf(t, e.Populate(cb))
+	errIf(t, e.Populate(context.Background(), cb))
 
-	errIf(t, e.PopulateFeaturePlugin([]string{}, plgnDir))
+	errIf(t, e.PopulateFeaturePlugin(context.Background(), []string{}, plgnDir))
 
 	defer deleteFile(plgnCLoc)
 	defer deleteFile(plgnLoc)
 
-	errIf(t, e.PopulateFeatureYaml([]string{}, loc1))
+	errIf(t, e.PopulateFeatureYaml(context.Background(), []string{}, loc1))
 
-	errIf(t, e.PopulateFeatureGroupString([]string{}, packedFeatureSet))
+	errIf(t, e.PopulateFeatureGroupString(context.Background(), []string{}, packedFeatureSet))
 
 	loc2 := loc(2)
 	errIf(t, writeYaml(loc2, rawComponents("rc")))
 	defer deleteFile(loc2)
 
-	errIf(t, e.PopulateComponentYaml([]string{}, loc2))
+	errIf(t, e.PopulateComponentYaml(context.Background(), []string{}, loc2))
 
 	loc3 := loc(3)
 	errIf(t, writeYaml(loc3, rawEntities))
 	defer deleteFile(loc3)
 
-	errIf(t, e.PopulateEntityYaml([]string{}, loc3))
+	errIf(t, e.PopulateEntityYaml(context.Background(), []string{}, loc3))
 
 	return e
 }
@@ -937,6 +968,8 @@ func TestPackage(t *testing.T) {
 
 	testOfEntity(t, e)
 
+	testOfClone(t, e.(env.Env))
+
 	a, f := testable(allFeatures())
 
 	xmfn := func(d *data.Vector) {
@@ -957,3 +990,369 @@ func TestPackage(t *testing.T) {
 
 	os.RemoveAll(rootDir)
 }
+
+var lintFeatures = `- tag: one
+  apply: constructor_string
+  values: [one]
+- tag: two
+  apply: constructor_nope
+  values: [two]
+- tag: one
+  apply: constructor_string
+  values: [other]
+`
+
+var lintComponents = `- tag: c
+  features:
+  - tag: two
+    apply: constructor_nope
+    values: [two]
+`
+
+func TestLint(t *testing.T) {
+	exist(rootDir)
+	fl, cl := loc(4), loc(5)
+	errIf(t, ioutil.WriteFile(fl, []byte(lintFeatures), 0660))
+	errIf(t, ioutil.WriteFile(cl, []byte(lintComponents), 0660))
+	defer deleteFile(fl)
+	defer deleteFile(cl)
+
+	missing := loc(6)
+	ps, err := env.Lint(
+		context.Background(),
+		env.LintFiles{Features: []string{fl, missing}, Components: []string{cl}},
+		env.SetConstructors(testConstructors...),
+	)
+	errIf(t, err)
+
+	var have []string
+	for _, p := range ps {
+		have = append(have, fmt.Sprintf("%s %s %d %t", p.Kind, p.Source.File, p.Source.Line, p.Warning))
+	}
+	expect := []string{
+		fmt.Sprintf("%s %s 4 false", env.UnknownConstructorProblem, fl),
+		fmt.Sprintf("%s %s 7 false", env.DuplicateTagProblem, fl),
+		fmt.Sprintf("%s  0 false", env.LoadProblem),
+		fmt.Sprintf("%s %s 4 false", env.LoadProblem, fl),
+		fmt.Sprintf("%s %s 1 false", env.UndefinedFeatureProblem, cl),
+		fmt.Sprintf("%s %s 1 true", env.UnusedFeatureProblem, fl),
+	}
+	assertEqual(t, "lint problems", have, expect)
+	if !env.HasErrors(ps) {
+		t.Error("expected lint problems to include errors")
+	}
+}
+
+func tConstructorLoadValues(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
+	list := r.MustGetValues()
+	ef := func() data.Item {
+		return data.NewStringsItem(tag, list...)
+	}
+	mf := func(d *data.Vector) {
+		d.Set(ef())
+	}
+	return feature.NewInformer("TEST_LOAD_VALUES", r.Group, tag, r.Values, list),
+		feature.NewEmitter(ef),
+		feature.NewMapper(mf)
+}
+
+// Emits the first value of the feature named by its only value, as read when
+// constructed.
+func tConstructorLoadFirst(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
+	var first string
+	if si, err := e.MustGetFeature(r.MustGetValues()[0]).EmitStrings(); err == nil {
+		first = si.ToStrings()[0]
+	}
+	ef := func() data.Item {
+		return data.NewStringItem(tag, first)
+	}
+	mf := func(d *data.Vector) {
+		d.Set(ef())
+	}
+	return feature.NewInformer("TEST_LOAD_FIRST", r.Group, tag, r.Values, []string{first}),
+		feature.NewEmitter(ef),
+		feature.NewMapper(mf)
+}
+
+// An Env holding a list of a and b, and a feature first of it.
+func loadEnv(t *testing.T) env.Env {
+	e, err := env.New(env.SetConstructors(
+		feature.NewConstructor("TEST_LOAD_VALUES", 50, tConstructorLoadValues),
+		feature.WithReferences(
+			feature.NewConstructor("TEST_LOAD_FIRST", 100, tConstructorLoadFirst),
+			func(r *feature.RawFeature) []string { return r.Values },
+		),
+	))
+	errIf(t, err)
+	errIf(t, e.PopulateFeatureBytes(context.Background(), []string{"g"}, "base", []byte(
+		"- tag: list\n  apply: test_load_values\n  values: [a, b]\n- tag: first\n  apply: test_load_first\n  values: [list]\n",
+	)))
+	return e
+}
+
+func firstOf(t *testing.T, e env.Env) string {
+	si, err := e.GetFeature("first").EmitString()
+	errIf(t, err)
+	return si.ToString()
+}
+
+func TestLoadMode(t *testing.T) {
+	ctx := context.Background()
+	e := loadEnv(t)
+	errIf(t, e.PopulateComponentBytes(ctx, nil, "base", []byte("- tag: c\n  features:\n  - tag: list\n")))
+
+	values := func() string {
+		return e.GetFeature("list").Raw()
+	}
+	first := func() string {
+		return firstOf(t, e)
+	}
+	populate := func(m feature.LoadMode, doc string) error {
+		return e.PopulateFeatureBytes(feature.WithLoadMode(ctx, m), nil, "next", []byte(doc))
+	}
+	redefine := "- tag: list\n  apply: test_load_values\n  values: [c, a]\n"
+
+	if err := populate(feature.LoadError, redefine); err == nil {
+		t.Error("redefining a feature differently did not return an error")
+	}
+	errIf(t, populate(feature.LoadSkip, redefine))
+	assertEqual(t, "skipped values", []string{values(), first()}, []string{"a,b", "a"})
+
+	errIf(t, populate(feature.LoadMerge, redefine))
+	assertEqual(t, "merged values", []string{values(), first()}, []string{"a,b,c", "a"})
+	if f := e.GetFeature("list"); !f.IsGroup("g") {
+		t.Error("merged feature lost its group")
+	}
+
+	errIf(t, populate(feature.LoadReplace, redefine))
+	assertEqual(t, "replaced values", []string{values(), first()}, []string{"c,a", "c"})
+
+	// a document sets its own mode over that of the context
+	errIf(t, populate(feature.LoadError, "load: replace\nfeatures:\n- tag: list\n  apply: test_load_values\n  values: [d]\n"))
+	assertEqual(t, "document replaced values", []string{values(), first()}, []string{"d", "d"})
+	if err := populate(feature.LoadError, "load: sometimes\nfeatures:\n- tag: list\n"); err == nil {
+		t.Error("an unknown document load mode did not return an error")
+	}
+
+	if err := e.PopulateComponentBytes(ctx, nil, "next", []byte("- tag: c\n  features:\n  - tag: first\n")); err == nil {
+		t.Error("redefining a component did not return an error")
+	}
+	errIf(t, e.PopulateComponentBytes(feature.WithLoadMode(ctx, feature.LoadMerge), nil, "next", []byte("- tag: c\n  features:\n  - tag: first\n")))
+	cs := e.ListComponents()
+	if len(cs) != 1 {
+		t.Fatalf("expected one component, have %d", len(cs))
+	}
+	assertEqual(t, "merged component features", cs[0].Features(), []string{"list", "first"})
+}
+
+func TestPatch(t *testing.T) {
+	ctx := context.Background()
+	e := loadEnv(t)
+	errIf(t, e.PopulateComponentBytes(ctx, nil, "base", []byte("- tag: c\n  features:\n  - tag: list\n- tag: d\n  features:\n  - tag: first\n")))
+	errIf(t, e.PopulateEntityBytes(ctx, nil, "base", []byte("- tag: en\n  components:\n  - tag: c\n")))
+
+	state := func(e env.Env) []string {
+		var cf, ec string
+		for _, c := range e.ListComponents() {
+			if c.Tag() == "c" {
+				cf = strings.Join(c.Features(), ",")
+			}
+		}
+		for _, en := range e.ListEntities() {
+			ec = strings.Join(en.Components(), ",")
+		}
+		return []string{
+			"list " + e.GetFeature("list").Raw(),
+			"first " + firstOf(t, e),
+			"c " + cf,
+			"en " + ec,
+		}
+	}
+	before := state(e)
+
+	if err := e.PopulatePatchBytes(ctx, []string{"x"}, "bad", []byte("- feature: list\n  remove: [z]\n")); err == nil {
+		t.Error("removing a value a feature does not have did not return an error")
+	}
+	if err := e.PopulatePatchBytes(ctx, []string{"x"}, "bad", []byte("- component: c\n  weights: [1]\n")); err == nil {
+		t.Error("weighting a component did not return an error")
+	}
+	assertEqual(t, "state after invalid patches", state(e), before)
+
+	errIf(t, e.PopulatePatchBytes(ctx, []string{"x"}, "expansion", []byte(
+		"- feature: list\n  append: [c]\n  remove: [a]\n  groups: [x]\n- component: c\n  append: [first]\n- entity: en\n  append: [d]\n",
+	)))
+	patched := []string{"list b,c", "first b", "c list,first", "en c,d"}
+	assertEqual(t, "patched state", state(e), patched)
+
+	clone, err := env.Clone(ctx, e)
+	errIf(t, err)
+	snap, err := env.NewSnapshot(e)
+	errIf(t, err)
+	b, err := yaml.Marshal(snap)
+	errIf(t, err)
+	var saved env.Snapshot
+	errIf(t, yaml.Unmarshal(b, &saved))
+	restored, err := env.Restore(ctx, &saved, env.SetConstructorRegistry(feature.CopyConstructors(e)))
+	errIf(t, err)
+	assertEqual(t, "restored patched state", state(restored), patched)
+	for _, en := range []env.Env{e, clone, restored} {
+		errIf(t, en.Remove("x"))
+		assertEqual(t, "state after depopulating the patch", state(en), before)
+		if !en.GetFeature("list").IsGroup("g") {
+			t.Error("reverting a patch lost a group of the patched feature")
+		}
+	}
+}
+
+func TestExtends(t *testing.T) {
+	ctx := context.Background()
+	e := loadEnv(t)
+	errIf(t, e.PopulateFeatureBytes(ctx, []string{"h"}, "extends", []byte(
+		"- tag: two\n  extends: pair\n  values: [c, d]\n"+
+			"- tag: pair\n  abstract: true\n  apply: test_load_values\n  values: [x, y]\n  group: [pairs]\n"+
+			"- tag: three\n  extends: two\n"+
+			"- tag: more\n  extends: list\n  values: [e]\n",
+	)))
+
+	if e.GetFeature("pair") != nil {
+		t.Error("an abstract feature was set")
+	}
+	for tag, raw := range map[string]string{"two": "c,d", "three": "c,d", "more": "e"} {
+		f := e.GetFeature(tag)
+		if f == nil {
+			t.Fatalf("feature %s was not set", tag)
+		}
+		if f.Raw() != raw || f.From() != "TEST_LOAD_VALUES" {
+			t.Errorf("feature %s inherited %s %s, not TEST_LOAD_VALUES %s", tag, f.From(), f.Raw(), raw)
+		}
+	}
+	if f := e.GetFeature("three"); !f.IsGroup("pairs") || !f.IsGroup("h") {
+		t.Errorf("feature three did not inherit its groups: %v", f.Group())
+	}
+	if !e.GetFeature("more").IsGroup("g") {
+		t.Error("a feature extending a feature already set did not inherit its group")
+	}
+
+	for doc, expect := range map[string]string{
+		"- tag: a\n  extends: b\n  apply: test_load_values\n- tag: b\n  extends: a\n": "extends itself",
+		"- tag: c\n  extends: missing\n":                                              "which is not defined",
+	} {
+		if err := e.PopulateFeatureBytes(ctx, nil, "bad", []byte(doc)); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected an error containing %q, have %v", expect, err)
+		}
+	}
+
+	clone, err := env.Clone(ctx, e)
+	errIf(t, err)
+	errIf(t, clone.PopulateFeatureBytes(ctx, nil, "later", []byte("- tag: four\n  extends: pair\n")))
+	if f := clone.GetFeature("four"); f == nil || f.Raw() != "x,y" {
+		t.Error("a template was not kept to extend in a later populate of a clone")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "include")
+	errIf(t, err)
+	defer os.RemoveAll(dir)
+	errIf(t, os.MkdirAll(filepath.Join(dir, "common", "nested"), 0770))
+	for name, doc := range map[string]string{
+		"main.yaml":              "include: [common]\nfeatures:\n- tag: second\n  apply: test_load_first\n  values: [b]\n",
+		"common/a.yaml":          "- tag: a\n  apply: test_load_values\n  values: [x, y]\n",
+		"common/nested/b.yaml":   "include: ../a.yaml\nfeatures:\n- tag: b\n  apply: test_load_first\n  values: [a]\n",
+		"cycle/one.yaml":         "include: two.yaml\n",
+		"cycle/two.yaml":         "include: [one.yaml]\n",
+		"missing.yaml":           "include: nothing/*.yaml\n",
+		"countfloyd.yaml":        "features: [main.yaml]\ngroups: [p]\nload: replace\n",
+		"broken/countfloyd.yaml": "features: [none.yaml]\n",
+	} {
+		errIf(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0770))
+		errIf(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(doc), 0660))
+	}
+
+	e := loadEnv(t)
+	errIf(t, e.PopulateFeatureYaml(ctx, []string{"i"}, filepath.Join(dir, "main.yaml")))
+	for tag, expect := range map[string]string{"a": "x,y", "b": "a", "second": "b"} {
+		if f := e.GetFeature(tag); f == nil || f.Raw() != expect {
+			t.Errorf("feature %s was not populated from an include", tag)
+		}
+	}
+	if si, err := e.GetFeature("b").EmitString(); err != nil || si.ToString() != "x" {
+		t.Error("an included file was not populated before the file including it")
+	}
+
+	for file, expect := range map[string]string{
+		"cycle/one.yaml": "includes itself",
+		"missing.yaml":   "matches no file",
+	} {
+		if err := e.PopulateFeatureYaml(ctx, nil, filepath.Join(dir, file)); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("expected an error containing %q, have %v", expect, err)
+		}
+	}
+
+	p, err := env.ReadProject(dir)
+	errIf(t, err)
+	assertEqual(t, "project features", p.Features, []string{filepath.Join(dir, "main.yaml")})
+	assertEqual(t, "project groups", p.Groups, []string{"p"})
+	if p.Load != "replace" {
+		t.Errorf("project load was %q, not replace", p.Load)
+	}
+	if _, err := env.ReadProject(filepath.Join(dir, "broken")); err == nil {
+		t.Error("a project listing a file that does not exist did not return an error")
+	}
+
+	wd, err := os.Getwd()
+	errIf(t, err)
+	errIf(t, os.Chdir(dir))
+	p, err = env.ReadProject(".")
+	os.Chdir(wd)
+	errIf(t, err)
+	if len(p.Features) != 1 || !filepath.IsAbs(p.Features[0]) {
+		t.Errorf("a project read from a relative path listed %v, not absolute paths", p.Features)
+	}
+}
+
+func TestVars(t *testing.T) {
+	ctx := context.Background()
+	e := loadEnv(t)
+	doc := []byte("vars:\n  level: easy\nfeatures:\n- tag: tuned\n  apply: test_load_values\n  values: [${level}, \"$${level}\"]\n")
+	errIf(t, e.PopulateFeatureBytes(ctx, nil, "vars", doc))
+	if f := e.GetFeature("tuned"); f == nil || f.Raw() != "easy,${level}" {
+		t.Error("a document was not substituted with the variables it defines")
+	}
+
+	vars, err := feature.ParseVars("level=hard")
+	errIf(t, err)
+	errIf(t, e.PopulateFeatureBytes(feature.WithLoadMode(feature.WithVars(ctx, vars), feature.LoadReplace), nil, "vars", doc))
+	if f := e.GetFeature("tuned"); f == nil || f.Raw() != "hard,${level}" {
+		t.Error("a variable provided when populating did not replace the variable a document defines")
+	}
+
+	err = e.PopulateFeatureBytes(ctx, nil, "bad", []byte("- tag: u\n  apply: test_load_values\n  values: [${nope}]\n"))
+	if err == nil || !strings.Contains(err.Error(), "bad:3: variable nope is not defined") {
+		t.Errorf("expected an undefined variable error located at its line, have %v", err)
+	}
+	if _, err := feature.ParseVars("level"); err == nil {
+		t.Error("a variable without a value did not return an error")
+	}
+	assertEqual(t, "split vars", feature.SplitVars("weights=1,2,3,level=hard"), []string{"weights=1,2,3", "level=hard"})
+}
+
+func TestReferences(t *testing.T) {
+	ctx := context.Background()
+	e := loadEnv(t)
+	errIf(t, e.PopulateComponentBytes(ctx, nil, "components", []byte("- tag: c\n  features:\n  - tag: list\n")))
+	errIf(t, e.PopulateEntityBytes(ctx, nil, "entities", []byte("- tag: en\n  components:\n  - tag: c\n")))
+	for _, c := range e.ListComponents() {
+		if c.Tag() == "c" {
+			assertEqual(t, "features of a component an entity only names", c.Features(), []string{"list"})
+		}
+	}
+
+	if err := e.PopulateComponentBytes(ctx, nil, "missing", []byte("- tag: d\n  features:\n  - tag: nothing\n")); err == nil {
+		t.Error("a component naming a feature that does not exist did not return an error")
+	}
+	if err := e.PopulateComponentBytes(ctx, nil, "different", []byte("- tag: d\n  features:\n  - tag: list\n    apply: test_load_values\n    values: [z]\n")); err == nil {
+		t.Error("a component defining an existing feature differently did not return an error")
+	}
+}
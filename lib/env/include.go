@@ -0,0 +1,123 @@
+package env
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+var (
+	IncludeError      = xrr.Xrror("%s includes %s, which matches no file").Out
+	IncludeCycleError = xrr.Xrror("%s includes itself through %s").Out
+)
+
+// A definition file and its content.
+type document struct {
+	file string
+	in   []byte
+}
+
+// Returns the files a path or glob pattern names, relative to dir unless
+// absolute, a directory naming every definition file within it, recursively.
+func expandPath(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			ret = append(ret, m)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(m, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && feature.DefinitionFile(p) {
+				found = append(found, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		ret = append(ret, found...)
+	}
+	return ret, nil
+}
+
+type includer struct {
+	read    map[string]bool
+	through []string
+	docs    []document
+	errs    feature.Errors
+}
+
+// Reads a file after every file it includes, in turn after what those include.
+func (i *includer) include(file string) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	for n, t := range i.through {
+		if t == abs {
+			cycle := append(append([]string{}, i.through[n+1:]...), abs)
+			i.errs.Add(IncludeCycleError(t, strings.Join(cycle, ", ")))
+			return
+		}
+	}
+	if i.read[abs] {
+		return
+	}
+	i.read[abs] = true
+
+	in, err := ioutil.ReadFile(file)
+	if err != nil {
+		i.errs.Add(err)
+		return
+	}
+	includes, err := feature.ParseIncludes(file, in)
+	if err != nil {
+		i.errs.Add(err)
+		return
+	}
+	i.through = append(i.through, abs)
+	for _, inc := range includes {
+		files, err := expandPath(filepath.Dir(file), inc)
+		switch {
+		case err != nil:
+			i.errs.Add(feature.Locate(feature.Source{File: file}, err))
+		case len(files) == 0:
+			i.errs.Add(IncludeError(file, inc))
+		}
+		for _, f := range files {
+			i.include(f)
+		}
+	}
+	i.through = i.through[:len(i.through)-1]
+	i.docs = append(i.docs, document{file, in})
+}
+
+// Reads each file, preceded by every file it includes, relative to itself,
+// and in turn by what those include. A file included more than once is read
+// once, and a file including itself, directly or not, is an error. Reading
+// continues past any file that fails, returning every error.
+func readIncludes(files []string) ([]document, error) {
+	i := &includer{read: make(map[string]bool)}
+	for _, file := range files {
+		i.include(file)
+	}
+	return i.docs, i.errs.Err()
+}
@@ -0,0 +1,312 @@
+package env
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+)
+
+// The kinds of Problem Lint reports.
+const (
+	LoadProblem                = "load"
+	UnknownConstructorProblem  = "unknown-constructor"
+	UnresolvedReferenceProblem = "unresolved-reference"
+	DuplicateTagProblem        = "duplicate-tag"
+	UndefinedFeatureProblem    = "undefined-feature"
+	UnusedFeatureProblem       = "unused-feature"
+)
+
+// A problem with definition files found by Lint. Warnings do not prevent the
+// files loading, but are likely mistakes.
+type Problem struct {
+	Source  feature.Source
+	Kind    string
+	Message string
+	Warning bool
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	msg := fmt.Sprintf("%s: %s: %s", level, p.Kind, p.Message)
+	if at := p.Source.String(); at != "" {
+		return fmt.Sprintf("%s: %s", at, msg)
+	}
+	return msg
+}
+
+// Definition files to Lint, by kind.
+type LintFiles struct {
+	Features, Components, Entities []string
+}
+
+type linter struct {
+	e          Env
+	problems   []Problem
+	features   map[string]*feature.RawFeature
+	defined    map[string]feature.Source
+	top        []*feature.RawFeature
+	used       map[string]bool
+	load       []*feature.RawFeature
+	refs       map[string][]string
+	values     map[string][]string
+	components []*feature.RawComponent
+	entities   []*feature.RawEntity
+}
+
+func (l *linter) add(s feature.Source, kind, format string, a ...interface{}) {
+	l.problems = append(l.problems, Problem{s, kind, fmt.Sprintf(format, a...), false})
+}
+
+func (l *linter) warn(s feature.Source, kind, format string, a ...interface{}) {
+	l.problems = append(l.problems, Problem{s, kind, fmt.Sprintf(format, a...), true})
+}
+
+func (l *linter) errors(err error) {
+	switch et := err.(type) {
+	case nil:
+	case feature.Errors:
+		for _, e := range et {
+			l.errors(e)
+		}
+	case feature.SourceError:
+		l.add(et.Source, LoadProblem, "%s", et.Err)
+	default:
+		l.add(feature.Source{}, LoadProblem, "%s", err)
+	}
+}
+
+func sameRaw(a, b *feature.RawFeature) bool {
+	return strings.EqualFold(a.Apply, b.Apply) &&
+		strings.Join(a.Values, ",") == strings.Join(b.Values, ",")
+}
+
+// Whether a definition is loaded deliberately over any of the same tag.
+func redefines(m feature.LoadMode) bool {
+	return m != "" && m != feature.LoadError
+}
+
+// Records a feature definition, reporting a tag defined differently more than
+// once, unless deliberately. The same feature is commonly defined by several
+// components.
+func (l *linter) feature(rf *feature.RawFeature) {
+	// a reference is checked with the component naming it
+	if rf.Reference() {
+		return
+	}
+	TAG := strings.ToUpper(rf.Tag)
+	if prev, ok := l.features[TAG]; ok {
+		if !sameRaw(prev, rf) && !redefines(rf.Load) {
+			l.add(rf.Source, DuplicateTagProblem, "feature %s is already defined differently at %s", TAG, prev.Source)
+		}
+		return
+	}
+	l.features[TAG] = rf
+	l.load = append(l.load, rf)
+	if rf.Extends != "" {
+		l.used[strings.ToUpper(rf.Extends)] = true
+	}
+	c, ok := l.e.GetConstructor(rf.Apply)
+	if !ok {
+		if rf.Apply != "" {
+			l.add(rf.Source, UnknownConstructorProblem, "feature %s applies unknown constructor %s", TAG, rf.Apply)
+		}
+		c, _ = l.e.GetConstructor("default")
+	}
+	// kept as defined, as constructors may alter values
+	l.refs[TAG] = feature.References(c, rf)
+	l.values[TAG] = append([]string{}, rf.Values...)
+}
+
+func (l *linter) tag(kind, tag string, s feature.Source, m feature.LoadMode) bool {
+	key := kind + ":" + tag
+	if prev, ok := l.defined[key]; ok {
+		if !redefines(m) {
+			l.add(s, DuplicateTagProblem, "%s %s is already defined at %s", kind, tag, prev)
+		}
+		return false
+	}
+	l.defined[key] = s
+	return true
+}
+
+func (l *linter) component(rc *feature.RawComponent) {
+	if l.tag("component", rc.Tag, rc.Source, rc.Load) {
+		l.components = append(l.components, rc)
+	}
+	for _, rf := range append(append([]*feature.RawFeature{}, rc.Defines...), rc.Features...) {
+		l.feature(rf)
+		l.used[strings.ToUpper(rf.Tag)] = true
+	}
+}
+
+// Reads each file after the files it includes, parsing each document read
+// once its variables are substituted.
+func readParse(ctx context.Context, files []string, parse func(string, []byte) error) error {
+	docs, err := readIncludes(files)
+	var errs feature.Errors
+	errs.Add(err)
+	for _, d := range docs {
+		in, err := feature.ExpandVars(ctx, d.file, d.in)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		errs.Add(parse(d.file, in))
+	}
+	return errs.Err()
+}
+
+func (l *linter) parse(ctx context.Context, files LintFiles) {
+	l.errors(readParse(ctx, files.Features, func(file string, b []byte) error {
+		rfs, err := feature.ParseFeatures(file, b)
+		for _, rf := range rfs {
+			l.feature(rf)
+			l.top = append(l.top, rf)
+		}
+		return err
+	}))
+	l.errors(readParse(ctx, files.Components, func(file string, b []byte) error {
+		rcs, err := feature.ParseComponents(file, b)
+		for _, rc := range rcs {
+			l.component(rc)
+		}
+		return err
+	}))
+	l.errors(readParse(ctx, files.Entities, func(file string, b []byte) error {
+		res, err := feature.ParseEntities(file, b)
+		for _, re := range res {
+			if l.tag("entity", re.Tag, re.Source, re.Load) {
+				l.entities = append(l.entities, re)
+			}
+			for _, rf := range re.Defines {
+				l.feature(rf)
+				l.used[strings.ToUpper(rf.Tag)] = true
+			}
+			for _, rc := range re.Components {
+				// as when loading, a component only named is one already defined
+				if _, ok := l.defined["component:"+rc.Tag]; ok && len(rc.Defines)+len(rc.Features) == 0 {
+					continue
+				}
+				l.component(rc)
+			}
+		}
+		return err
+	}))
+}
+
+// Loads the first definition of each feature, component and entity, duplicates
+// being reported while parsing.
+func (l *linter) populate(ctx context.Context) {
+	l.errors(l.e.AddRaw(l.load...))
+	l.errors(l.e.Dequeue(ctx))
+	l.errors(l.e.SetRawComponent(l.components...))
+	l.errors(l.e.SetRawEntity(l.entities...))
+}
+
+// Whether a reference names a feature or group, including features defined but
+// failing to construct, which are reported separately.
+func (l *linter) resolves(ref string) bool {
+	_, defined := l.features[strings.ToUpper(ref)]
+	return defined || len(l.e.List(ref)) > 0
+}
+
+// Any value of another feature naming a feature or group uses it, whatever the
+// constructor.
+func (l *linter) use(by, v string) {
+	mark := func(tag string) {
+		if TAG := strings.ToUpper(tag); TAG != by {
+			l.used[TAG] = true
+		}
+	}
+	for _, part := range strings.Split(v, ";") {
+		if _, ok := l.features[strings.ToUpper(part)]; ok {
+			mark(part)
+		}
+		for _, rf := range l.e.List(part) {
+			mark(rf.Tag)
+		}
+	}
+}
+
+func (l *linter) check() {
+	var tags []string
+	for t := range l.features {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		rf := l.features[t]
+		for _, ref := range l.refs[t] {
+			if !l.resolves(ref) {
+				l.add(rf.Source, UnresolvedReferenceProblem, "feature %s refers to %s, which is not a feature or group", t, ref)
+			}
+		}
+		for _, v := range l.values[t] {
+			l.use(t, v)
+		}
+	}
+
+	for _, c := range l.e.ListComponents() {
+		src, _ := feature.SourceOf(c)
+		for _, f := range append(append([]string{}, c.Defines()...), c.Features()...) {
+			if l.e.GetFeature(f) == nil {
+				l.add(src, UndefinedFeatureProblem, "component %s refers to undefined feature %s", c.Tag(), f)
+			}
+		}
+	}
+	for _, en := range l.e.ListEntities() {
+		src, _ := feature.SourceOf(en)
+		for _, f := range en.Defines() {
+			if l.e.GetFeature(f) == nil {
+				l.add(src, UndefinedFeatureProblem, "entity %s refers to undefined feature %s", en.Tag(), f)
+			}
+		}
+	}
+
+	for _, rf := range l.top {
+		TAG := strings.ToUpper(rf.Tag)
+		if !l.used[TAG] && l.features[TAG] == rf && !rf.Abstract {
+			l.warn(rf.Source, UnusedFeatureProblem, "feature %s is not used by any component, entity or other feature", TAG)
+		}
+	}
+}
+
+// Loads the provided definition files into a new, isolated Env configured with
+// the provided Config, reporting any problems found: files that fail to load
+// or construct, unknown constructors, unresolved feature or group references,
+// duplicate tags, components or entities referring to undefined features, and
+// as warnings, features nothing uses.
+func Lint(ctx context.Context, files LintFiles, cnf ...Config) ([]Problem, error) {
+	e, err := New(cnf...)
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{
+		e:        e,
+		features: make(map[string]*feature.RawFeature),
+		defined:  make(map[string]feature.Source),
+		used:     make(map[string]bool),
+		refs:     make(map[string][]string),
+		values:   make(map[string][]string),
+	}
+	l.parse(ctx, files)
+	l.populate(ctx)
+	l.check()
+	return l.problems, ctx.Err()
+}
+
+// Whether any of the provided problems is an error rather than a warning.
+func HasErrors(ps []Problem) bool {
+	for _, p := range ps {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
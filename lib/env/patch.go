@@ -0,0 +1,290 @@
+package env
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+)
+
+// A patch applied to an Env, with what it targets and the groups it was
+// populated under.
+type appliedPatch struct {
+	target string
+	groups []string
+	patch  *feature.RawPatch
+}
+
+// A feature, component or entity as it was before any patch.
+type unpatched struct {
+	feature   *feature.RawFeature
+	component *SavedComponent
+	entity    *SavedEntity
+}
+
+// The patches applied to an Env in order, and what each target was before
+// them, so the patches of a group may be reverted.
+type patchLog struct {
+	applied []appliedPatch
+	base    map[string]unpatched
+}
+
+func newPatchLog() patchLog {
+	return patchLog{base: make(map[string]unpatched)}
+}
+
+func (p patchLog) copy() patchLog {
+	n := newPatchLog()
+	n.applied = append(n.applied, p.applied...)
+	for k, v := range p.base {
+		n.base[k] = v
+	}
+	return n
+}
+
+// Returns the patches applied, in order, and what each target was before them,
+// by patch key, to save in a Snapshot.
+func (p patchLog) save() ([]SavedPatch, map[string]SavedUnpatched) {
+	var ps []SavedPatch
+	for _, a := range p.applied {
+		rp := *a.patch
+		src := rp.Source
+		rp.Source = feature.Source{}
+		ps = append(ps, SavedPatch{rp, a.groups, src})
+	}
+	var base map[string]SavedUnpatched
+	for k, u := range p.base {
+		var su SavedUnpatched
+		if u.feature != nil {
+			def := *u.feature
+			src := def.Source
+			def.Source = feature.Source{}
+			su.Feature = &SavedFeature{def, src}
+		}
+		su.Component, su.Entity = u.component, u.entity
+		if base == nil {
+			base = make(map[string]SavedUnpatched)
+		}
+		base[k] = su
+	}
+	return ps, base
+}
+
+// Records again the patches applied and what each target was before them, as
+// saved, the targets already being patched.
+func (p *patchLog) restore(ps []SavedPatch, base map[string]SavedUnpatched) error {
+	for _, sp := range ps {
+		rp := sp.Patch
+		rp.Source = sp.Source
+		kind, tag, err := rp.Target()
+		if err != nil {
+			return feature.Locate(rp.Source, err)
+		}
+		p.applied = append(p.applied, appliedPatch{patchKey(kind, tag), sp.Groups, &rp})
+	}
+	for k, su := range base {
+		var u unpatched
+		if su.Feature != nil {
+			rf := su.Feature.Definition
+			rf.Source = su.Feature.Source
+			u.feature = &rf
+		}
+		u.component, u.entity = su.Component, su.Entity
+		p.base[k] = u
+	}
+	return nil
+}
+
+func patchKey(kind, tag string) string {
+	return kind + ":" + tag
+}
+
+func splitPatchKey(key string) (string, string) {
+	spl := strings.SplitN(key, ":", 2)
+	return spl[0], spl[1]
+}
+
+// What a patch of the provided kind and tag would change, as it is now, and
+// whether it exists.
+func (e *env) current(kind, tag string) (unpatched, bool) {
+	var u unpatched
+	switch kind {
+	case "feature":
+		f := e.GetFeature(tag)
+		if f == nil {
+			return u, false
+		}
+		def, ok := feature.DefinitionOf(f)
+		if !ok {
+			def = f.RawFeature()
+		}
+		u.feature = &def
+	case "component":
+		if c := feature.FindComponent(e, tag); c != nil {
+			sc := saveComponent(c)
+			u.component = &sc
+		}
+		return u, u.component != nil
+	case "entity":
+		if en := feature.FindEntity(e, tag); en != nil {
+			se := saveEntity(en)
+			u.entity = &se
+		}
+		return u, u.entity != nil
+	}
+	return u, true
+}
+
+// Applies a patch, recording it under the provided groups and, for the first
+// patch of its target, what the target was before.
+func (e *env) applyPatch(groups []string, p *feature.RawPatch) error {
+	kind, tag, err := p.Target()
+	if err != nil {
+		return feature.Locate(p.Source, err)
+	}
+	key := patchKey(kind, tag)
+	_, had := e.patches.base[key]
+	base, _ := e.current(kind, tag)
+	if err := feature.ApplyPatch(e, p); err != nil {
+		return err
+	}
+	if !had {
+		e.patches.base[key] = base
+	}
+	e.patches.applied = append(e.patches.applied, appliedPatch{key, append([]string{}, groups...), p})
+	return nil
+}
+
+// Restores the target of the provided key as it was before any patch.
+func (e *env) restore(key string) error {
+	u := e.patches.base[key]
+	switch {
+	case u.feature != nil:
+		rf := *u.feature
+		rf.Group = append([]string{}, rf.Group...)
+		rf.Values = append([]string{}, rf.Values...)
+		return e.ReplaceFeature(&rf, feature.LoadReplace)
+	case u.component != nil:
+		return e.SetRawComponent(u.component.raw(feature.LoadReplace))
+	case u.entity != nil:
+		return e.SetRawEntity(u.entity.raw(feature.LoadReplace))
+	}
+	return nil
+}
+
+func inGroups(have, groups []string) bool {
+	for _, h := range have {
+		for _, g := range groups {
+			if h == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reverts the patches populated under any of the provided groups, restoring
+// what each patched as it was before any patch, then applying again the
+// patches of it remaining, in order.
+func (e *env) unpatch(groups []string) error {
+	reverted := make(map[string]bool)
+	var keep []appliedPatch
+	for _, a := range e.patches.applied {
+		if inGroups(a.groups, groups) {
+			reverted[a.target] = true
+			continue
+		}
+		keep = append(keep, a)
+	}
+	e.patches.applied = keep
+
+	var keys []string
+	for k := range reverted {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var errs feature.Errors
+	for _, key := range keys {
+		errs.Add(e.restore(key))
+		patched := false
+		for _, a := range keep {
+			if a.target == key {
+				errs.Add(feature.ApplyPatch(e, a.patch))
+				patched = true
+			}
+		}
+		if !patched {
+			delete(e.patches.base, key)
+		}
+	}
+	return errs.Err()
+}
+
+// Drops the patches of, and what was before them for, anything no longer
+// defined.
+func (e *env) forget() {
+	gone := make(map[string]bool)
+	for key := range e.patches.base {
+		if _, ok := e.current(splitPatchKey(key)); !ok {
+			gone[key] = true
+			delete(e.patches.base, key)
+		}
+	}
+	var keep []appliedPatch
+	for _, a := range e.patches.applied {
+		if !gone[a.target] {
+			keep = append(keep, a)
+		}
+	}
+	e.patches.applied = keep
+}
+
+// Removes every feature in the provided groups, first reverting the patches
+// populated under any of them.
+func (e *env) Remove(groups ...string) error {
+	var errs feature.Errors
+	errs.Add(e.unpatch(groups))
+	errs.Add(e.Features.Remove(groups...))
+	e.forget()
+	return errs.Err()
+}
+
+func (e *env) populatePatches(ctx context.Context, groups []string, name string, in []byte) error {
+	in, err := feature.ExpandVars(ctx, name, in)
+	if err != nil {
+		return err
+	}
+	ps, err := feature.ParsePatches(name, in)
+	if err != nil {
+		return err
+	}
+	var errs feature.Errors
+	for _, p := range ps {
+		if err := ctx.Err(); err != nil {
+			errs.Add(err)
+			break
+		}
+		errs.Add(e.applyPatch(groups, p))
+	}
+	return errs.Err()
+}
+
+// Applies the patches of each file, of any feature.Format, after the files
+// each includes, recording each under the provided groups so depopulating any
+// of them reverts it. Every error is returned.
+func (e *env) PopulatePatchYaml(ctx context.Context, groups []string, files ...string) error {
+	docs, err := readIncludes(files)
+	var errs feature.Errors
+	errs.Add(err)
+	for _, d := range docs {
+		errs.Add(e.populatePatches(ctx, groups, d.file, d.in))
+	}
+	return errs.Err()
+}
+
+// Applies the patches of a document of any feature.Format, errors and sources
+// being located within the provided name, if any.
+func (e *env) PopulatePatchBytes(ctx context.Context, groups []string, name string, in []byte) error {
+	return e.populatePatches(ctx, groups, name, in)
+}
@@ -192,7 +192,11 @@ func loadPath(l *loader, path string, c, f bool) ([]feature.Constructor, []featu
 		if llfn, ok = luf.(func() []feature.Feature); !ok {
 			return nil, nil, OpenPluginError(path, "error with plugin Features (func() []feature.Feature)")
 		}
-		return nil, llfn(), nil
+		var fs []feature.Feature
+		for _, lf := range llfn() {
+			fs = append(fs, feature.WithSource(lf, feature.Source{Plugin: path}))
+		}
+		return nil, fs, nil
 	}
 
 	return nil, nil, nil
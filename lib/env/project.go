@@ -0,0 +1,100 @@
+package env
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The file a Project is read from when given a directory.
+const ProjectFile = "countfloyd.yaml"
+
+var ProjectPathError = xrr.Xrror("project %s lists %s, which matches nothing").Out
+
+// Everything populated together, in order: plugins, features, components,
+// entities, then patches, each a path, glob pattern or directory relative to
+// the project file. Vars are substituted in every document, under any the
+// populate provides itself, e.g.
+//
+//	constructor-plugins: [plugins/constructors]
+//	features: [features/*.yaml]
+//	components: [components]
+//	entities: [entities.yaml]
+//	groups: [world]
+//	load: replace
+//	vars:
+//	  difficulty: normal
+type Project struct {
+	ConstructorPlugins []string          `yaml:"constructor-plugins,omitempty"`
+	FeaturePlugins     []string          `yaml:"feature-plugins,omitempty"`
+	Features           []string          `yaml:",omitempty"`
+	Components         []string          `yaml:",omitempty"`
+	Entities           []string          `yaml:",omitempty"`
+	Patches            []string          `yaml:",omitempty"`
+	Groups             []string          `yaml:",omitempty"`
+	Load               string            `yaml:",omitempty"`
+	Vars               map[string]string `yaml:",omitempty"`
+}
+
+// Reads the Project of the provided file, or of the ProjectFile of the
+// provided directory, every path it lists resolved to the files, or for
+// plugins the directories, it names, as absolute paths. Every error is
+// returned.
+func ReadProject(path string) (*Project, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, ProjectFile)
+	}
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Project{}
+	if err := yaml.Unmarshal(in, p); err != nil {
+		return nil, feature.Locate(feature.Source{File: path}, err)
+	}
+	if _, err := feature.ParseLoadMode(p.Load); err != nil {
+		return nil, feature.Locate(feature.Source{File: path}, err)
+	}
+
+	dir := filepath.Dir(path)
+	var errs feature.Errors
+	resolve := func(paths []string, files bool) []string {
+		var ret []string
+		for _, pattern := range paths {
+			var found []string
+			var err error
+			switch {
+			case files:
+				found, err = expandPath(dir, pattern)
+			case filepath.IsAbs(pattern):
+				found, err = filepath.Glob(pattern)
+			default:
+				found, err = filepath.Glob(filepath.Join(dir, pattern))
+			}
+			switch {
+			case err != nil:
+				errs.Add(feature.Locate(feature.Source{File: path}, err))
+			case len(found) == 0:
+				errs.Add(ProjectPathError(path, pattern))
+			}
+			ret = append(ret, found...)
+		}
+		return ret
+	}
+	p.ConstructorPlugins = resolve(p.ConstructorPlugins, false)
+	p.FeaturePlugins = resolve(p.FeaturePlugins, false)
+	p.Features = resolve(p.Features, true)
+	p.Components = resolve(p.Components, true)
+	p.Entities = resolve(p.Entities, true)
+	p.Patches = resolve(p.Patches, true)
+	return p, errs.Err()
+}
@@ -0,0 +1,293 @@
+package env
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The kinds of PluginLoad.
+const (
+	ConstructorPlugin = "constructor"
+	FeaturePlugin     = "feature"
+)
+
+// Plugin directories loaded into an Env, recorded so a snapshot loads them
+// again on restore.
+type PluginLoad struct {
+	Kind   string
+	Dirs   []string
+	Groups []string `yaml:",omitempty"`
+}
+
+// A feature definition with where it was defined.
+type SavedFeature struct {
+	Definition feature.RawFeature `yaml:",inline"`
+	Source     feature.Source     `yaml:",omitempty"`
+}
+
+// A component, with where it was defined.
+type SavedComponent struct {
+	Tag      string
+	Defines  []string       `yaml:",omitempty"`
+	Features []string       `yaml:",omitempty"`
+	Source   feature.Source `yaml:",omitempty"`
+}
+
+// Saves a component with where it was defined.
+func saveComponent(c feature.Component) SavedComponent {
+	src, _ := feature.SourceOf(c)
+	return SavedComponent{c.Tag(), c.Defines(), c.Features(), src}
+}
+
+// The RawComponent to set a SavedComponent again under the provided LoadMode.
+func (sc SavedComponent) raw(m feature.LoadMode) *feature.RawComponent {
+	return &feature.RawComponent{
+		Tag:      sc.Tag,
+		Defines:  feature.ReferenceTags(sc.Defines),
+		Features: feature.ReferenceTags(sc.Features),
+		Source:   sc.Source,
+		Load:     m,
+	}
+}
+
+// An entity, with where it was defined.
+type SavedEntity struct {
+	Tag        string
+	Defines    []string       `yaml:",omitempty"`
+	Components []string       `yaml:",omitempty"`
+	Source     feature.Source `yaml:",omitempty"`
+}
+
+// Saves an entity with where it was defined.
+func saveEntity(en feature.Entity) SavedEntity {
+	src, _ := feature.SourceOf(en)
+	return SavedEntity{en.Tag(), en.Defines(), en.Components(), src}
+}
+
+// The RawEntity to set a SavedEntity again under the provided LoadMode.
+func (se SavedEntity) raw(m feature.LoadMode) *feature.RawEntity {
+	re := &feature.RawEntity{
+		Tag:     se.Tag,
+		Defines: feature.ReferenceTags(se.Defines),
+		Source:  se.Source,
+		Load:    m,
+	}
+	for _, c := range se.Components {
+		re.Components = append(re.Components, &feature.RawComponent{Tag: c})
+	}
+	return re
+}
+
+// A patch applied to an Env, with the groups it was populated under and where
+// it was defined.
+type SavedPatch struct {
+	Patch  feature.RawPatch
+	Groups []string       `yaml:",omitempty"`
+	Source feature.Source `yaml:",omitempty"`
+}
+
+// A patched feature, component or entity as it was before any patch.
+type SavedUnpatched struct {
+	Feature   *SavedFeature   `yaml:",omitempty"`
+	Component *SavedComponent `yaml:",omitempty"`
+	Entity    *SavedEntity    `yaml:",omitempty"`
+}
+
+// Everything held by an Env: the plugins loaded into it, the definitions of
+// its features with their groups and provenance, its components and entities,
+// the state of any feature keeping state, by tag, and the patches applied to
+// it, with what each target was before them, so depopulating a patch group
+// after restoring reverts them.
+type Snapshot struct {
+	Plugins    []PluginLoad              `yaml:",omitempty"`
+	Features   []SavedFeature            `yaml:",omitempty"`
+	Components []SavedComponent          `yaml:",omitempty"`
+	Entities   []SavedEntity             `yaml:",omitempty"`
+	State      map[string]string         `yaml:",omitempty"`
+	Patches    []SavedPatch              `yaml:",omitempty"`
+	Unpatched  map[string]SavedUnpatched `yaml:",omitempty"`
+}
+
+var UnsavedFeatureError = xrr.Xrror("feature %s has no definition to snapshot").Out
+
+// Returns a Snapshot of the provided Env. Features derived by a constructor
+// are left to their parent to derive again, and features from plugins to the
+// plugins to load again. Patched definitions are saved as patched, with the
+// patches applied and what they patched as it was before them.
+func NewSnapshot(e Env) (*Snapshot, error) {
+	s := &Snapshot{State: make(map[string]string)}
+	if en, ok := e.(*env); ok {
+		s.Plugins = append(s.Plugins, en.plugins...)
+		s.Patches, s.Unpatched = en.patches.save()
+	}
+
+	var errs feature.Errors
+	for _, rf := range e.ListAll() {
+		TAG := strings.ToUpper(rf.Tag)
+		f := e.GetFeature(TAG)
+		if st, ok := feature.StateOf(f); ok {
+			s.State[TAG] = st.State()
+		}
+		def, ok := feature.DefinitionOf(f)
+		switch {
+		case !ok && rf.Source.Plugin == "":
+			errs.Add(UnsavedFeatureError(TAG))
+		case ok && def.Source.Parent == "":
+			src := def.Source
+			def.Source = feature.Source{}
+			s.Features = append(s.Features, SavedFeature{def, src})
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	// templates are abstract features, kept to extend again after restoring
+	for _, t := range e.ListTemplates() {
+		src := t.Source
+		t.Source = feature.Source{}
+		s.Features = append(s.Features, SavedFeature{t, src})
+	}
+	sort.Slice(s.Features, func(i, j int) bool {
+		return s.Features[i].Definition.Tag < s.Features[j].Definition.Tag
+	})
+
+	for _, c := range e.ListComponents() {
+		s.Components = append(s.Components, saveComponent(c))
+	}
+	sort.Slice(s.Components, func(i, j int) bool { return s.Components[i].Tag < s.Components[j].Tag })
+
+	for _, en := range e.ListEntities() {
+		s.Entities = append(s.Entities, saveEntity(en))
+	}
+	sort.Slice(s.Entities, func(i, j int) bool { return s.Entities[i].Tag < s.Entities[j].Tag })
+
+	return s, nil
+}
+
+// Loads constructor plugins again as PopulateConstructorPlugin, but keeps any
+// constructor of the same tag already set.
+func (e *env) restoreConstructorPlugin(ctx context.Context, dirs ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := e.Loader.AddDirs(dirs...); err != nil {
+		return err
+	}
+	nc, err := e.Loader.LoadConstructor()
+	if err != nil {
+		return err
+	}
+	for _, c := range nc {
+		if _, ok := e.GetConstructor(c.Tag()); ok {
+			continue
+		}
+		if err := e.Constructors.SetConstructor(c); err != nil {
+			return err
+		}
+	}
+	e.plugins = append(e.plugins, PluginLoad{Kind: ConstructorPlugin, Dirs: dirs})
+	return nil
+}
+
+// Returns a new Env configured with the provided Config holding everything in
+// the provided Snapshot, loading its plugins again, then setting its features,
+// components and entities, the patches applied to them and the state of
+// features keeping state. Features
+// are constructed with the constructors the Config sets, e.g. a copy of those
+// of a live Env with SetConstructorRegistry, of which plugin constructors
+// already set are kept.
+func Restore(ctx context.Context, s *Snapshot, cnf ...Config) (Env, error) {
+	ne, err := New(cnf...)
+	if err != nil {
+		return nil, err
+	}
+	e := ne.(*env)
+
+	for _, p := range s.Plugins {
+		switch p.Kind {
+		case ConstructorPlugin:
+			err = e.restoreConstructorPlugin(ctx, p.Dirs...)
+		case FeaturePlugin:
+			err = e.PopulateFeaturePlugin(ctx, p.Groups, p.Dirs...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sf := range s.Features {
+		rf := sf.Definition
+		rf.Source = sf.Source
+		if err := e.AddRaw(&rf); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.Dequeue(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, sc := range s.Components {
+		if err := e.SetRawComponent(sc.raw(feature.LoadError)); err != nil {
+			return nil, err
+		}
+	}
+	for _, se := range s.Entities {
+		if err := e.SetRawEntity(se.raw(feature.LoadError)); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.patches.restore(s.Patches, s.Unpatched); err != nil {
+		return nil, err
+	}
+
+	if err := SetState(e, s.State); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Returns the state of each feature of the provided Env keeping state, by tag.
+func State(e Env) map[string]string {
+	ret := make(map[string]string)
+	for _, rf := range e.ListAll() {
+		TAG := strings.ToUpper(rf.Tag)
+		if st, ok := feature.StateOf(e.GetFeature(TAG)); ok {
+			ret[TAG] = st.State()
+		}
+	}
+	return ret
+}
+
+// Sets the state of each feature keeping state by tag, returning every error.
+func SetState(e Env, state map[string]string) error {
+	var errs feature.Errors
+	for tag, st := range state {
+		f := e.GetFeature(tag)
+		if f == nil {
+			errs.Add(feature.NotFoundError("feature", tag))
+			continue
+		}
+		if sf, ok := feature.StateOf(f); ok {
+			errs.Add(sf.SetState(st))
+		}
+	}
+	return errs.Err()
+}
+
+// Copies the state of each feature of from keeping state to the feature of the
+// same tag in to.
+func copyState(from, to Env) error {
+	state := make(map[string]string)
+	for _, rf := range from.ListAll() {
+		if st, ok := feature.StateOf(from.GetFeature(rf.Tag)); ok {
+			if to.GetFeature(rf.Tag) != nil {
+				state[strings.ToUpper(rf.Tag)] = st.State()
+			}
+		}
+	}
+	return SetState(to, state)
+}
@@ -0,0 +1,40 @@
+package env
+
+import (
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+var ReferenceError = xrr.Xrror("%s %s refers to %s %s, which does not exist").Out
+
+// Checks that every feature a component or entity refers to, and every
+// component an entity refers to, exists in the provided Env, returning an
+// error for each that does not.
+func Validate(e Env) error {
+	var errs feature.Errors
+	features := func(kind, tag string, fs []string) {
+		for _, f := range fs {
+			if e.GetFeature(f) == nil {
+				errs.Add(ReferenceError(kind, tag, "feature", f))
+			}
+		}
+	}
+
+	components := make(map[string]bool)
+	for _, c := range e.ListComponents() {
+		components[c.Tag()] = true
+		features("component", c.Tag(), c.Defines())
+		features("component", c.Tag(), c.Features())
+	}
+
+	for _, en := range e.ListEntities() {
+		features("entity", en.Tag(), en.Defines())
+		for _, c := range en.Components() {
+			if !components[c] {
+				errs.Add(ReferenceError("entity", en.Tag(), "component", c))
+			}
+		}
+	}
+
+	return errs.Err()
+}
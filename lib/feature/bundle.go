@@ -0,0 +1,202 @@
+package feature
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// Copies what a RawFeature defines: its tag, groups, constructor and values.
+func definition(rf *RawFeature) *RawFeature {
+	ret := &RawFeature{
+		Group:  append([]string{}, rf.Group...),
+		Tag:    rf.Tag,
+		Apply:  rf.Apply,
+		Values: append([]string{}, rf.Values...),
+		Source: rf.Source,
+	}
+	if rf.Constructor != nil {
+		ret.Apply = rf.Constructor.Tag()
+	}
+	return ret
+}
+
+// Returns the definition a Feature was constructed from, with the tag of its
+// constructor and its values before construction, if it was constructed.
+func DefinitionOf(f Feature) (RawFeature, bool) {
+	if lf, ok := f.(loadedFeature); ok && lf.def != nil {
+		return *lf.def, true
+	}
+	return RawFeature{}, false
+}
+
+// The version of the bundle format Bundle.Value encodes.
+const BundleVersion = 1
+
+// The most bytes of yaml a bundle decodes to, so a small bundle cannot expand
+// to exhaust memory.
+const MaxBundleSize = 64 << 20
+
+const bundlePrefix = "cfb"
+
+var (
+	BundleFormatError     = xrr.Xrror("not a countfloyd bundle").Out
+	BundleVersionError    = xrr.Xrror("bundle version %d is not supported, at most version %d is").Out
+	BundleChecksumError   = xrr.Xrror("bundle checksum %s does not match its content").Out
+	BundleSizeError       = xrr.Xrror("bundle content is larger than %d bytes").Out
+	UndefinedFeatureError = xrr.Xrror("feature %s has no definition to bundle, e.g. as it is from a plugin").Out
+)
+
+// Features, with the constructor tags and values they were defined with, and
+// the components and entities made of them, shared as a single string.
+type Bundle struct {
+	Version    int
+	Features   []*RawFeature
+	Components []*RawComponent
+	Entities   []*RawEntity
+}
+
+func containsAll(in map[string]bool, tags []string) bool {
+	for _, t := range tags {
+		if !in[strings.ToUpper(t)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a Bundle of the features in the provided group, or of every feature
+// if the group is empty, with every component made only of those features and
+// every entity made only of those components. Features derived by a
+// constructor are left to their parent to derive again.
+func NewBundle(e CEnv, group string) (*Bundle, error) {
+	var rfs []RawFeature
+	switch group {
+	case "":
+		rfs = e.ListAll()
+	default:
+		rfs = e.List(group)
+	}
+
+	b := &Bundle{Version: BundleVersion}
+	var errs Errors
+	has := make(map[string]bool)
+	for _, rf := range rfs {
+		TAG := strings.ToUpper(rf.Tag)
+		has[TAG] = true
+		def, ok := DefinitionOf(e.GetFeature(TAG))
+		switch {
+		case !ok:
+			errs.Add(UndefinedFeatureError(TAG))
+		case def.Source.Parent == "":
+			def.Source = Source{}
+			b.Features = append(b.Features, &def)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(b.Features, func(i, j int) bool { return b.Features[i].Tag < b.Features[j].Tag })
+
+	components := make(map[string]bool)
+	for _, c := range e.ListComponents() {
+		if containsAll(has, c.Defines()) && containsAll(has, c.Features()) {
+			components[strings.ToUpper(c.Tag())] = true
+			b.Components = append(b.Components, &RawComponent{
+				Tag:      c.Tag(),
+				Defines:  ReferenceTags(c.Defines()),
+				Features: ReferenceTags(c.Features()),
+			})
+		}
+	}
+	sort.Slice(b.Components, func(i, j int) bool { return b.Components[i].Tag < b.Components[j].Tag })
+
+	for _, en := range e.ListEntities() {
+		if containsAll(has, en.Defines()) && containsAll(components, en.Components()) {
+			re := &RawEntity{Tag: en.Tag(), Defines: ReferenceTags(en.Defines())}
+			for _, c := range en.Components() {
+				re.Components = append(re.Components, &RawComponent{Tag: c})
+			}
+			b.Entities = append(b.Entities, re)
+		}
+	}
+	sort.Slice(b.Entities, func(i, j int) bool { return b.Entities[i].Tag < b.Entities[j].Tag })
+
+	return b, nil
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Encodes the Bundle as "cfb<version>.<checksum>.<content>", the content being
+// zlib compressed yaml, base64 encoded, and the checksum that of the yaml.
+func (b *Bundle) Value() (string, error) {
+	y, err := yaml.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	w := zlib.NewWriter(buf)
+	w.Write(y)
+	w.Close()
+	return fmt.Sprintf(
+		"%s%d.%s.%s",
+		bundlePrefix,
+		b.Version,
+		checksum(y),
+		base64.StdEncoding.EncodeToString(buf.Bytes()),
+	), nil
+}
+
+// Decodes a Bundle encoded by Value, returning an error for an unsupported
+// version, content larger than MaxBundleSize or not matching its checksum.
+func DecodeBundle(s string) (*Bundle, error) {
+	spl := strings.SplitN(strings.TrimSpace(s), ".", 3)
+	if len(spl) != 3 || !strings.HasPrefix(spl[0], bundlePrefix) {
+		return nil, BundleFormatError()
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(spl[0], bundlePrefix))
+	if err != nil {
+		return nil, BundleFormatError()
+	}
+	if version < 1 || version > BundleVersion {
+		return nil, BundleVersionError(version, BundleVersion)
+	}
+	z, err := base64.StdEncoding.DecodeString(spl[2])
+	if err != nil {
+		return nil, err
+	}
+	r, err := zlib.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return nil, err
+	}
+	y, err := ioutil.ReadAll(io.LimitReader(r, MaxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(y) > MaxBundleSize {
+		return nil, BundleSizeError(MaxBundleSize)
+	}
+	if checksum(y) != spl[1] {
+		return nil, BundleChecksumError(spl[1])
+	}
+	b := &Bundle{}
+	if err := yaml.Unmarshal(y, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
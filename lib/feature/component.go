@@ -16,12 +16,15 @@ type RawComponent struct {
 	Tag      string
 	Defines  []*RawFeature
 	Features []*RawFeature
+	Source   Source   `yaml:"-"`
+	Load     LoadMode `yaml:"-"`
 }
 
 type component struct {
 	tag      string
 	defines  []string
 	features []string
+	source   Source
 }
 
 func (c *component) Tag() string {
@@ -36,12 +39,17 @@ func (c *component) Features() []string {
 	return c.features
 }
 
+func (c *component) Source() Source {
+	return c.source
+}
+
 type Components interface {
 	SetRawComponent(...*RawComponent) error
 	SetComponent(...Component) error
 	GetComponent(float64, string, ...string) []*data.Vector
 	MustGetComponent(float64, string, ...string) []*data.Vector
 	ListComponents() []Component
+	RemoveComponent(...string)
 }
 
 type components struct {
@@ -56,7 +64,6 @@ func NewComponents(e CEnv) Components {
 }
 
 func (c *components) SetRawComponent(rcs ...*RawComponent) error {
-	var s []Component
 	for _, v := range rcs {
 		t := v.Tag
 		var d, f []string
@@ -66,18 +73,43 @@ func (c *components) SetRawComponent(rcs ...*RawComponent) error {
 		for _, vf := range v.Features {
 			f = append(f, vf.Tag)
 		}
-		s = append(s, &component{t, d, f})
+		if err := c.set(v.Load, &component{t, d, f, v.Source}); err != nil {
+			return Locate(v.Source, err)
+		}
+	}
+	return nil
+}
+
+// Sets a component under the provided LoadMode, merging the defines and
+// features of any existing component of the same tag under LoadMerge.
+func (c *components) set(m LoadMode, v Component) error {
+	nt := v.Tag()
+	if prev, exists := c.has[nt]; exists {
+		switch m {
+		case LoadSkip:
+			return nil
+		case LoadReplace:
+		case LoadMerge:
+			src, _ := SourceOf(v)
+			v = &component{
+				nt,
+				mergeValues(prev.Defines(), v.Defines()),
+				mergeValues(prev.Features(), v.Features()),
+				src,
+			}
+		default:
+			return ExistsError("component", nt)
+		}
 	}
-	return c.SetComponent(s...)
+	c.has[nt] = v
+	return nil
 }
 
 func (c *components) SetComponent(cs ...Component) error {
 	for _, v := range cs {
-		nt := v.Tag()
-		if _, exists := c.has[nt]; exists {
-			return ExistsError("component", nt)
+		if err := c.set(LoadError, v); err != nil {
+			return err
 		}
-		c.has[nt] = v
 	}
 	return nil
 }
@@ -128,3 +160,19 @@ func (c *components) ListComponents() []Component {
 	}
 	return ret
 }
+
+// Returns the component of the provided tag, or nil if there is none.
+func FindComponent(cs Components, tag string) Component {
+	for _, c := range cs.ListComponents() {
+		if c.Tag() == tag {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *components) RemoveComponent(tags ...string) {
+	for _, t := range tags {
+		delete(c.has, t)
+	}
+}
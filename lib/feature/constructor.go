@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
 )
 
 type CEnv interface {
@@ -51,30 +52,113 @@ func (c constructor) Construct(name string, r *RawFeature, e CEnv) Feature {
 	return NewFeature(c.fn(name, r, e))
 }
 
+// A Constructor reporting which of the values of a RawFeature must name a
+// feature or group, allowing definitions to be checked before loading.
+type Referrer interface {
+	References(*RawFeature) []string
+}
+
+type ReferenceFn func(*RawFeature) []string
+
+type referrer struct {
+	Constructor
+	fn ReferenceFn
+}
+
+func (r referrer) References(rf *RawFeature) []string {
+	return r.fn(rf)
+}
+
+// Returns the provided Constructor as a Referrer using the provided function.
+func WithReferences(c Constructor, fn ReferenceFn) Constructor {
+	return referrer{c, fn}
+}
+
+// Returns the references of a RawFeature if its Constructor is a Referrer.
+func References(c Constructor, rf *RawFeature) []string {
+	if o, ok := c.(override); ok {
+		c = o.Constructor
+	}
+	if r, ok := c.(Referrer); ok {
+		return r.References(rf)
+	}
+	return nil
+}
+
 type Constructors interface {
 	SetConstructor(...Constructor) error
 	GetConstructor(string) (Constructor, bool)
 	ListConstructors() []Constructor
 }
 
+// A registry of Constructor by tag. A registry layered over a base registry
+// holds its own constructors and falls back to the base for any tag it does
+// not hold.
 type constructors struct {
-	has map[string]Constructor
+	base Constructors
+	has  map[string]Constructor
 }
 
+// Returns a standalone, empty Constructors.
 func NewConstructors() Constructors {
-	return &constructors{make(map[string]Constructor)}
+	return &constructors{has: make(map[string]Constructor)}
+}
+
+// Returns an empty Constructors layered over the provided base, e.g. Internal.
+// Constructors set on the layer are not set on the base.
+func LayerConstructors(base Constructors) Constructors {
+	return &constructors{base: base, has: make(map[string]Constructor)}
 }
 
+// Returns a copy of the provided Constructors sharing any base, where
+// constructors set on either afterwards are not set on the other.
+func CopyConstructors(c Constructors) Constructors {
+	if cs, ok := c.(*constructors); ok {
+		n := &constructors{base: cs.base, has: make(map[string]Constructor)}
+		for k, v := range cs.has {
+			n.has[k] = v
+		}
+		return n
+	}
+	n := NewConstructors()
+	n.SetConstructor(c.ListConstructors()...)
+	return n
+}
+
+type override struct {
+	Constructor
+}
+
+// Marks a Constructor as deliberately shadowing any constructor with the same
+// tag in the base of a layered registry.
+func Override(c Constructor) Constructor {
+	return override{c}
+}
+
+func isOverride(c Constructor) bool {
+	_, ok := c.(override)
+	return ok
+}
+
+var ShadowError = xrr.Xrror("construct %s shadows an existing construct, use feature.Override to shadow it deliberately").Out
+
 func SetConstructor(cns ...Constructor) error {
 	return Internal.SetConstructor(cns...)
 }
 
+// Sets the provided constructors, returning an error for a tag already set
+// on this registry, or present in the base and not marked with Override.
 func (c *constructors) SetConstructor(cns ...Constructor) error {
 	for _, cn := range cns {
 		tag := cn.Tag()
 		if _, exists := c.has[tag]; exists {
 			return ExistsError("construct", tag)
 		}
+		if c.base != nil && !isOverride(cn) {
+			if _, exists := c.base.GetConstructor(tag); exists {
+				return ShadowError(tag)
+			}
+		}
 		c.has[tag] = cn
 	}
 	return nil
@@ -85,8 +169,11 @@ func GetConstructor(key string) (Constructor, bool) {
 }
 
 func (c *constructors) GetConstructor(key string) (Constructor, bool) {
-	if c, exists := c.has[strings.ToUpper(key)]; exists {
-		return c, true
+	if cn, exists := c.has[strings.ToUpper(key)]; exists {
+		return cn, true
+	}
+	if c.base != nil {
+		return c.base.GetConstructor(key)
 	}
 	return nil, false
 }
@@ -97,12 +184,20 @@ func ListConstructors() []Constructor {
 
 func (c *constructors) ListConstructors() []Constructor {
 	var ret []Constructor
-	for _, c := range c.has {
-		ret = append(ret, c)
+	for _, cn := range c.has {
+		ret = append(ret, cn)
+	}
+	if c.base != nil {
+		for _, cn := range c.base.ListConstructors() {
+			if _, shadowed := c.has[cn.Tag()]; !shadowed {
+				ret = append(ret, cn)
+			}
+		}
 	}
 	return ret
 }
 
+// The registry of built in constructors, the base of every Env registry.
 var Internal Constructors
 
 func init() {
@@ -52,10 +52,11 @@ func collectionMemberIndexed(tag string, r *feature.RawFeature, e feature.CEnv)
 			Apply:       "default",
 			Values:      []string{v},
 			Constructor: Default(),
+			Source:      feature.DerivedSource(r),
 		})
 	}
 	for _, ndf := range nf {
-		e.SetFeature(ndf)
+		e.SetFeature(ndf.WithContext(r.Context()))
 	}
 
 	ef := func() data.Item {
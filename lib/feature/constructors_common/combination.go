@@ -1,6 +1,7 @@
 package constructors_common
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -52,14 +53,16 @@ func (sl StringReplacer) ValueNoSame() (string, bool) {
 	return strings.Join(spl, "+"), true
 }
 
-func Combinations(list Replacer, selectNum int, repeatable bool, buf int) (c chan Replacer) {
+// Combinations sends every combination of selectNum items from list on the
+// returned channel, which is closed when enumeration completes or ctx is done.
+func Combinations(ctx context.Context, list Replacer, selectNum int, repeatable bool, buf int) (c chan Replacer) {
 	c = make(chan Replacer, buf)
 	index := make([]int, list.Len(), list.Len())
 	for i := 0; i < list.Len(); i++ {
 		index[i] = i
 	}
 
-	var comb_generator func([]int, int, int) chan []int
+	var comb_generator func(context.Context, []int, int, int) chan []int
 	switch {
 	case repeatable:
 		comb_generator = repeated_combinations
@@ -69,29 +72,44 @@ func Combinations(list Replacer, selectNum int, repeatable bool, buf int) (c cha
 
 	go func() {
 		defer close(c)
-		for comb := range comb_generator(index, selectNum, buf) {
-			c <- list.Replace(comb)
+		for comb := range comb_generator(ctx, index, selectNum, buf) {
+			select {
+			case c <- list.Replace(comb):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
 	return
 }
 
-func combinations(list []int, select_num, buf int) (c chan []int) {
+func send(ctx context.Context, c chan []int, v []int) bool {
+	select {
+	case c <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func combinations(ctx context.Context, list []int, select_num, buf int) (c chan []int) {
 	c = make(chan []int, buf)
 	go func() {
 		defer close(c)
 		switch {
 		case select_num == 0:
-			c <- []int{}
+			send(ctx, c, []int{})
 		case select_num == len(list):
-			c <- list
+			send(ctx, c, list)
 		case len(list) < select_num:
 			return
 		default:
 			for i := 0; i < len(list); i++ {
-				for sub_comb := range combinations(list[i+1:], select_num-1, buf) {
-					c <- append([]int{list[i]}, sub_comb...)
+				for sub_comb := range combinations(ctx, list[i+1:], select_num-1, buf) {
+					if !send(ctx, c, append([]int{list[i]}, sub_comb...)) {
+						return
+					}
 				}
 			}
 		}
@@ -99,19 +117,23 @@ func combinations(list []int, select_num, buf int) (c chan []int) {
 	return
 }
 
-func repeated_combinations(list []int, select_num, buf int) (c chan []int) {
+func repeated_combinations(ctx context.Context, list []int, select_num, buf int) (c chan []int) {
 	c = make(chan []int, buf)
 	go func() {
 		defer close(c)
 		if select_num == 1 {
 			for v := range list {
-				c <- []int{v}
+				if !send(ctx, c, []int{v}) {
+					return
+				}
 			}
 			return
 		}
 		for i := 0; i < len(list); i++ {
-			for sub_comb := range repeated_combinations(list[i:], select_num-1, buf) {
-				c <- append([]int{list[i]}, sub_comb...)
+			for sub_comb := range repeated_combinations(ctx, list[i:], select_num-1, buf) {
+				if !send(ctx, c, append([]int{list[i]}, sub_comb...)) {
+					return
+				}
 			}
 		}
 	}()
@@ -119,14 +141,17 @@ func repeated_combinations(list []int, select_num, buf int) (c chan []int) {
 }
 
 func CombinationStrings() feature.Constructor {
-	return feature.NewConstructor("COMBINATION_STRINGS", 90, combinationOfStrings)
+	return feature.WithReferences(
+		feature.NewConstructor("COMBINATION_STRINGS", 90, combinationOfStrings),
+		valuesFrom(3, -1),
+	)
 }
 
 func combinationOfStrings(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
 	rp, num, repeat, same, buf := csArgParser(e, r.MustGetValues())
 
 	var vals []string
-	for c := range Combinations(rp, num, repeat, buf) {
+	for c := range Combinations(r.Context(), rp, num, repeat, buf) {
 		if !same {
 			if val, ok := c.ValueNoSame(); ok {
 				vals = append(vals, val)
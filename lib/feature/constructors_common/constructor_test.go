@@ -1,11 +1,13 @@
 package constructors_common
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
@@ -434,7 +436,7 @@ func createGroupValue(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	e.Populate(b)
+	e.Populate(context.Background(), b)
 
 	g := e.GetGroup(groupTag)
 	gv := g.Value()
@@ -460,17 +462,17 @@ func testEnv(t *testing.T) feature.CEnv {
 
 	err = e.SetConstructor(customConstructor)
 
-	err = e.Populate(b)
+	err = e.Populate(context.Background(), b)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = e.PopulateFeatureYaml([]string{}, loc)
+	err = e.PopulateFeatureYaml(context.Background(), []string{}, loc)
 	if err != nil {
 		t.Error(err)
 	}
 
-	err = e.PopulateFeatureGroupString([]string{}, featureGroup)
+	err = e.PopulateFeatureGroupString(context.Background(), []string{}, featureGroup)
 	if err != nil {
 		t.Error(err)
 	}
@@ -494,3 +496,28 @@ func TestConstructors(t *testing.T) {
 		}
 	}
 }
+
+func TestCombinationsCancel(t *testing.T) {
+	var list StringReplacer
+	for i := 0; i < 40; i++ {
+		list = append(list, fmt.Sprintf("%d:%d", i, i))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := Combinations(ctx, list, 10, false, 1)
+	<-c
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range c {
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("combinations did not stop after context cancellation")
+	}
+}
@@ -2,6 +2,8 @@ package constructors_common
 
 import (
 	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
 	"github.com/Laughs-In-Flowers/data"
@@ -32,6 +34,7 @@ func roundRobin(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Info
 		return data.NewStringsItem(tag, values...)
 	}
 
+	var mu sync.Mutex
 	limit := len(values) - 1
 	idx := limit
 	nxt := func(curr, limit int) int {
@@ -42,10 +45,30 @@ func roundRobin(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Info
 	}
 
 	mf := func(f *data.Vector) {
+		mu.Lock()
 		idx = nxt(idx, limit)
 		v := values[idx]
+		mu.Unlock()
 		f.Set(data.NewStringItem(tag, v))
 	}
 
-	return construct("ROUND_ROBIN", r.Group, tag, values, values, ef, mf)
+	// the state is the index of the value last mapped
+	get := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strconv.Itoa(idx)
+	}
+	set := func(s string) error {
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 || i > limit {
+			return feature.StateError(s, tag)
+		}
+		mu.Lock()
+		idx = i
+		mu.Unlock()
+		return nil
+	}
+
+	i, em, _ := construct("ROUND_ROBIN", r.Group, tag, values, values, ef, mf)
+	return i, em, feature.NewStatefulMapper(mf, get, set)
 }
@@ -1,7 +1,6 @@
 package constructors_common
 
 import (
-	mr "math/rand"
 	"strconv"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
@@ -20,7 +19,7 @@ func random(from, tag string, r *feature.RawFeature, e feature.CEnv) (feature.In
 	var ssv func() string
 	switch {
 	case lv > 1:
-		ssv = func() string { return vals[mr.Intn(lv)] }
+		ssv = func() string { return vals[rnd.Intn(lv)] }
 	default:
 		ssv = func() string { return vals[0] }
 	}
@@ -49,7 +48,10 @@ func simpleRandom(tag string, r *feature.RawFeature, e feature.CEnv) (feature.In
 }
 
 func SourcedRandom() feature.Constructor {
-	return feature.NewConstructor("SOURCED_RANDOM", 10000, sourcedRandom)
+	return feature.WithReferences(
+		feature.NewConstructor("SOURCED_RANDOM", 10000, sourcedRandom),
+		valuesFrom(1, 2),
+	)
 }
 
 func sourcedRandom(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
@@ -9,7 +9,22 @@ import (
 
 // A set constructor that takes provided keys and links them to multiple features in a return map.
 func Set() feature.Constructor {
-	return feature.NewConstructor("SET", 50, set)
+	return feature.WithReferences(feature.NewConstructor("SET", 50, set), setReferences)
+}
+
+// Each value of a set names a feature or group, or is key;feature.
+func setReferences(r *feature.RawFeature) []string {
+	var ret []string
+	for _, v := range r.Values {
+		spl := strings.Split(v, ";")
+		switch len(spl) {
+		case 1:
+			ret = append(ret, spl[0])
+		case 2:
+			ret = append(ret, spl[1])
+		}
+	}
+	return ret
 }
 
 func set(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
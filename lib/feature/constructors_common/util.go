@@ -2,17 +2,17 @@ package constructors_common
 
 import (
 	"fmt"
-	"log"
 	"math"
 	mr "math/rand"
-	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/xrr"
 )
 
 func construct(
@@ -147,7 +147,7 @@ func mirrorInts(e feature.CEnv, ss []string) []string {
 }
 
 func maybe(n float64) bool {
-	maybe := mr.Float64()
+	maybe := rnd.Float64()
 	if maybe <= n {
 		return true
 	}
@@ -211,7 +211,7 @@ func floatKeysToString(in map[float64]string) map[string]string {
 func shuffleStrings(s []string) {
 	n := len(s)
 	for i := n - 1; i > 0; i-- {
-		j := mr.Intn(i + 1)
+		j := rnd.Intn(i + 1)
 		s[i], s[j] = s[j], s[i]
 	}
 }
@@ -229,15 +229,33 @@ func (t *tuple) StringReverse() string {
 	return fmt.Sprintf("%s_%s", t.two, t.one)
 }
 
+var ArgumentLengthError = xrr.Xrror("provided args %s of length %d, expected at least %d").Out
+
+// Returns the provided args, panicking with an ArgumentLengthError, returned
+// as a construction error, if there are fewer than expected.
 func argsMustBeLength(e feature.CEnv, args []string, expects int) []string {
 	length := len(args)
 	if length < expects {
-		log.Printf("provided args %s of length %d, expected at least %d", args, length, expects)
-		os.Exit(-1)
+		panic(ArgumentLengthError(args, length, expects))
 	}
 	return args
 }
 
+// Returns a ReferenceFn reporting the values from index from onwards, up to
+// but not including index to, or to the end when to is less than zero.
+func valuesFrom(from, to int) feature.ReferenceFn {
+	return func(r *feature.RawFeature) []string {
+		v := r.Values
+		if to >= 0 && to < len(v) {
+			v = v[:to]
+		}
+		if from >= len(v) {
+			return nil
+		}
+		return v[from:]
+	}
+}
+
 type kf struct {
 	k string
 	f feature.Feature
@@ -305,6 +323,29 @@ func smoothKey(nk ...string) string {
 	return strings.Join(cp, ".")
 }
 
-func init() {
-	mr.Seed(time.Now().UTC().UnixNano())
+type lockedSource struct {
+	mu  sync.Mutex
+	src mr.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(n)
+}
+
+// The source of randomness for the built in constructors, safe for features
+// mapping concurrently.
+var rnd = mr.New(&lockedSource{src: mr.NewSource(time.Now().UTC().UnixNano())})
+
+// Seeds the randomness of the built in constructors, making what they generate
+// reproducible when features are mapped in a fixed order.
+func Seed(n int64) {
+	rnd.Seed(n)
 }
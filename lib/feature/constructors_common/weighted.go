@@ -189,8 +189,9 @@ func withNumbersWeighting(in []string, numbers ...string) []string {
 }
 
 func WeightedStringWithWeights() feature.Constructor {
-	return feature.NewConstructor(
-		"WEIGHTED_STRING_WITH_WEIGHTS", 150, wsWithWeights,
+	return feature.WithReferences(
+		feature.NewConstructor("WEIGHTED_STRING_WITH_WEIGHTS", 150, wsWithWeights),
+		valuesFrom(0, 1),
 	)
 }
 
@@ -224,8 +225,9 @@ func normalizeWeighting(in []string, x ...string) []string {
 }
 
 func WeightedStringWithNormalizedWeights() feature.Constructor {
-	return feature.NewConstructor(
-		"WEIGHTED_STRING_WITH_NORMALIZED_WEIGHTS", 150, wsWithNormalizedWeights,
+	return feature.WithReferences(
+		feature.NewConstructor("WEIGHTED_STRING_WITH_NORMALIZED_WEIGHTS", 150, wsWithNormalizedWeights),
+		valuesFrom(0, 1),
 	)
 }
 
@@ -16,12 +16,15 @@ type RawEntity struct {
 	Tag        string
 	Defines    []*RawFeature
 	Components []*RawComponent
+	Source     Source   `yaml:"-"`
+	Load       LoadMode `yaml:"-"`
 }
 
 type entity struct {
 	tag        string
 	defines    []string
 	components []string
+	source     Source
 }
 
 func (e *entity) Tag() string {
@@ -36,12 +39,17 @@ func (e *entity) Components() []string {
 	return e.components
 }
 
+func (e *entity) Source() Source {
+	return e.source
+}
+
 type Entities interface {
 	SetRawEntity(...*RawEntity) error
 	SetEntity(...Entity) error
 	GetEntity(float64, string) []*data.Vector
 	MustGetEntity(float64, string) []*data.Vector
 	ListEntities() []Entity
+	RemoveEntity(...string)
 }
 
 type entities struct {
@@ -56,7 +64,6 @@ func NewEntities(e CEnv) Entities {
 }
 
 func (e *entities) SetRawEntity(res ...*RawEntity) error {
-	var ex []Entity
 	for _, v := range res {
 		tag := v.Tag
 		var d []string
@@ -67,19 +74,43 @@ func (e *entities) SetRawEntity(res ...*RawEntity) error {
 		for _, vv := range v.Components {
 			cs = append(cs, vv.Tag)
 		}
-		ent := &entity{tag, d, cs}
-		ex = append(ex, ent)
+		if err := e.set(v.Load, &entity{tag, d, cs, v.Source}); err != nil {
+			return Locate(v.Source, err)
+		}
+	}
+	return nil
+}
+
+// Sets an entity under the provided LoadMode, merging the defines and
+// components of any existing entity of the same tag under LoadMerge.
+func (e *entities) set(m LoadMode, v Entity) error {
+	nt := v.Tag()
+	if prev, exists := e.has[nt]; exists {
+		switch m {
+		case LoadSkip:
+			return nil
+		case LoadReplace:
+		case LoadMerge:
+			src, _ := SourceOf(v)
+			v = &entity{
+				nt,
+				mergeValues(prev.Defines(), v.Defines()),
+				mergeValues(prev.Components(), v.Components()),
+				src,
+			}
+		default:
+			return ExistsError("entity", nt)
+		}
 	}
-	return e.SetEntity(ex...)
+	e.has[nt] = v
+	return nil
 }
 
 func (e *entities) SetEntity(es ...Entity) error {
 	for _, v := range es {
-		nt := v.Tag()
-		if _, exists := e.has[nt]; exists {
-			return ExistsError("entity", nt)
+		if err := e.set(LoadError, v); err != nil {
+			return err
 		}
-		e.has[nt] = v
 	}
 	return nil
 }
@@ -113,3 +144,19 @@ func (e *entities) ListEntities() []Entity {
 	}
 	return ret
 }
+
+// Returns the entity of the provided tag, or nil if there is none.
+func FindEntity(es Entities, tag string) Entity {
+	for _, en := range es.ListEntities() {
+		if en.Tag() == tag {
+			return en
+		}
+	}
+	return nil
+}
+
+func (e *entities) RemoveEntity(tags ...string) {
+	for _, t := range tags {
+		delete(e.has, t)
+	}
+}
@@ -0,0 +1,14 @@
+package feature
+
+// Removes the Format of the provided name, so a test registering a format
+// leaves the registry as it found it.
+func UnregisterFormat(name string) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for i, f := range formats {
+		if f.Name == name {
+			formats = append(formats[:i], formats[i+1:]...)
+			return
+		}
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"encoding/base64"
-	"fmt"
 	"io"
-	"os"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
@@ -111,11 +109,10 @@ func (i *informer) Raw() string {
 
 func (i *informer) RawFeature() RawFeature {
 	return RawFeature{
-		i.group,
-		i.tag,
-		i.from,
-		strings.Split(i.raw, ","),
-		nil,
+		Group:  i.group,
+		Tag:    i.tag,
+		Apply:  i.from,
+		Values: strings.Split(i.raw, ","),
 	}
 }
 
@@ -286,11 +283,14 @@ func DecodeFeatureGroup(s string) (*FeatureGroup, error) {
 type Features interface {
 	AddFeature(...Feature)
 	SetFeature(*RawFeature) error
+	ReplaceFeature(*RawFeature, LoadMode) error
 	GetFeature(string) Feature
 	MustGetFeature(string) Feature
 	GetGroup(string) *FeatureGroup
 	List(string) []RawFeature
+	ListAll() []RawFeature
 	Remove(...string) error
+	RemoveFeature(...string)
 }
 
 type features struct {
@@ -311,6 +311,18 @@ func (fs *features) AddFeature(nfs ...Feature) {
 	}
 }
 
+var ConstructError = xrr.Xrror("constructing %s with %s: %v").Out
+
+// Constructs a RawFeature, returning any panic of the constructor as an error.
+func construct(key string, rf *RawFeature, e CEnv) (f Feature, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ConstructError(key, rf.Constructor.Tag(), r)
+		}
+	}()
+	return rf.Constructor.Construct(key, rf, e), nil
+}
+
 func (fs *features) SetFeature(rf *RawFeature) error {
 	KEY := strings.ToUpper(rf.Tag)
 	if _, exists := fs.has[KEY]; exists {
@@ -322,7 +334,16 @@ func (fs *features) SetFeature(rf *RawFeature) error {
 			return err
 		}
 	}
-	fs.has[KEY] = rf.Constructor.Construct(KEY, rf, fs.e)
+	// copied before constructing, as constructors may alter values
+	def := definition(rf)
+	nf, err := construct(KEY, rf, fs.e)
+	if err != nil {
+		return err
+	}
+	if err := rf.Context().Err(); err != nil {
+		return err
+	}
+	fs.has[KEY] = loadedFeature{nf, rf.Source, def}
 	return nil
 }
 
@@ -336,9 +357,8 @@ func (fs *features) GetFeature(key string) Feature {
 func (fs *features) MustGetFeature(key string) Feature {
 	f := fs.GetFeature(key)
 	if f == nil {
-		logErr := NotFoundError("feature", key)
-		fmt.Fprintf(os.Stderr, "FATAL: %s\n", logErr)
-		os.Exit(-9000)
+		// recovered as a construction error when constructing
+		panic(NotFoundError("feature", key))
 	}
 	return f
 }
@@ -358,6 +378,15 @@ func (fs *features) List(group string) []RawFeature {
 	return g.List()
 }
 
+// Lists the RawFeature of every feature, regardless of group.
+func (fs *features) ListAll() []RawFeature {
+	var ret []RawFeature
+	for _, f := range fs.has {
+		ret = append(ret, f.RawFeature())
+	}
+	return ret
+}
+
 func (fs *features) remove(group string) error {
 	for k, f := range fs.has {
 		if f.IsGroup(group) {
@@ -377,6 +406,13 @@ func (fs *features) Remove(groups ...string) error {
 	return nil
 }
 
+// Removes features by tag, regardless of group.
+func (fs *features) RemoveFeature(tags ...string) {
+	for _, t := range tags {
+		delete(fs.has, strings.ToUpper(t))
+	}
+}
+
 func NewData(n float64) *data.Vector {
 	d := data.New("")
 	d.Set(data.NewFloat64Item("meta.priority", n))
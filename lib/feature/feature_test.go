@@ -1,6 +1,7 @@
 package feature_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -732,26 +733,37 @@ func testOfConstructor(t *testing.T, e feature.CEnv) {
 		t.Errorf("Constructor does not exist: %s", ck)
 	}
 
+	for _, c := range testConstructors {
+		if _, exists := e.GetConstructor(c.Tag()); !exists {
+			t.Errorf("Env constructor does not exist: %s", c.Tag())
+		}
+		if _, exists := feature.GetConstructor(c.Tag()); exists {
+			t.Errorf("Env constructor exists outside the env: %s", c.Tag())
+		}
+	}
+
 	cl1 := feature.ListConstructors()
 	cl2 := e.ListConstructors()
-	if len(cl1) != len(cl2) {
-		t.Error("expected equal constructor lists")
+	if len(cl1)+len(testConstructors) != len(cl2) {
+		t.Error("expected env constructors to be the built in and env constructors")
 	}
 	csl1 := constructorSort(cl1)
-	csl2 := constructorSort(cl2)
 	sort.Sort(csl1)
-	sort.Sort(csl2)
-	l := csl1.Len() - 1
-	for i := 0; i <= l; i++ {
-		t1, t2 := csl1[i].Tag(), csl2[i].Tag()
-		if t1 != t2 {
-			t.Errorf("Constructor tags should be the same but are not: %s - %s", t1, t2)
-		}
-		o1, o2 := csl1[i].Order(), csl1[i].Order()
+	for _, c1 := range csl1 {
+		c2, exists := e.GetConstructor(c1.Tag())
+		if !exists {
+			t.Errorf("Built in constructor does not exist in env: %s", c1.Tag())
+			continue
+		}
+		o1, o2 := c1.Order(), c2.Order()
 		if o1 != o2 {
 			t.Errorf("Constructor order should be the same but are not: %d - %d", o1, o2)
 		}
 	}
+
+	if err := e.SetConstructor(additionalConstructor); err == nil {
+		t.Error("Setting a constructor shadowing a built in constructor did not return an error.")
+	}
 }
 
 func testOfFeature(t *testing.T, e feature.CEnv) {
@@ -813,7 +825,7 @@ func testOfFeatureGroup(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	e.Populate(b)
+	e.Populate(context.Background(), b)
 
 	testOfFeature(t, e)
 
@@ -888,41 +900,41 @@ func testEnv(t *testing.T) feature.CEnv {
 	errIf(t, err)
 
 	var e env.Env
-	e, err = env.New()
+	e, err = env.New(env.SetConstructors(testConstructors...))
 	errIf(t, err)
 
-	errIf(t, e.Populate(b))
+	errIf(t, e.Populate(context.Background(), b))
 
 	//errIf(t, writePlugin(plgnCLoc))
 
 	//errIf(t, compilePlugin(plgnCLoc))
 
-	//errIf(t, e.PopulateConstructorPlugin(plgnDir))
+	//errIf(t, e.PopulateConstructorPlugin(context.Background(), plgnDir))
 
 	//var cb []byte
 	//cb, err = yaml.Marshal(&rawPluginConstructors)
-	//errIf(t, e.Populate(cb))
+	//errIf(t, e.Populate(context.Background(), cb))
 
-	//errIf(t, e.PopulateFeaturePlugin([]string{}, plgnDir))
+	//errIf(t, e.PopulateFeaturePlugin(context.Background(), []string{}, plgnDir))
 
 	//defer deleteFile(plgnCLoc)
 	//defer deleteFile(plgnLoc)
 
-	errIf(t, e.PopulateFeatureYaml([]string{}, loc1))
+	errIf(t, e.PopulateFeatureYaml(context.Background(), []string{}, loc1))
 
-	errIf(t, e.PopulateFeatureGroupString([]string{}, packedFeatureSet))
+	errIf(t, e.PopulateFeatureGroupString(context.Background(), []string{}, packedFeatureSet))
 
 	loc2 := loc(2)
 	errIf(t, writeYaml(loc2, rawComponents("rc")))
 	defer deleteFile(loc2)
 
-	errIf(t, e.PopulateComponentYaml([]string{}, loc2))
+	errIf(t, e.PopulateComponentYaml(context.Background(), []string{}, loc2))
 
 	loc3 := loc(3)
 	errIf(t, writeYaml(loc3, rawEntities))
 	defer deleteFile(loc3)
 
-	errIf(t, e.PopulateEntityYaml([]string{}, loc3))
+	errIf(t, e.PopulateEntityYaml(context.Background(), []string{}, loc3))
 
 	return e
 }
@@ -954,3 +966,68 @@ func TestPackage(t *testing.T) {
 
 	os.RemoveAll(rootDir)
 }
+
+func TestParse(t *testing.T) {
+	rfs, err := feature.ParseFeatures("f.yaml", []byte("- tag: one\n  values: [a]\n- tag: two\n  values: [b]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rfs) != 2 || rfs[1].Source.String() != "f.yaml:3:3" {
+		t.Errorf("expected the second feature at f.yaml:3:3, have %v", rfs)
+	}
+
+	for y, expect := range map[string]string{
+		"- tag: [":                          "f.yaml:1: ",
+		"tag: one":                          "f.yaml:1:1: expected a list of features",
+		"- tag: one\n  values:\n    a: 1\n": "f.yaml:3:5: ",
+	} {
+		if _, err := feature.ParseFeatures("f.yaml", []byte(y)); err == nil || !strings.HasPrefix(err.Error(), expect) {
+			t.Errorf("expected an error beginning %q for %q, have %v", expect, y, err)
+		}
+	}
+
+	_, err = feature.ParseEntities("e.yaml", []byte("- tag: e\n  components:\n  - tag: c\n    defines:\n    - tag: x\n      values: {a: 1}\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "e.yaml:6:7: ") {
+		t.Errorf("expected an error located at e.yaml:6:7, have %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	rfs, err := feature.ParseFeatures("f.json", []byte(`[{"tag": "one", "values": ["a"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rfs) != 1 || rfs[0].Source.String() != "f.json:1:2" {
+		t.Errorf("expected a json feature at f.json:1:2, have %v", rfs)
+	}
+
+	rcs, err := feature.ParseComponents("inline", []byte(`{"components": [{"tag": "c", "features": [{"tag": "one"}]}]}`))
+	if err != nil || len(rcs) != 1 || len(rcs[0].Features) != 1 {
+		t.Errorf("a json document without an extension was not parsed: %v %v", rcs, err)
+	}
+
+	if _, err := feature.ParseFeatures("bad.json", []byte(`[{"tag": "one",}]`)); err == nil || !strings.HasPrefix(err.Error(), "bad.json: invalid json document") {
+		t.Errorf("expected an invalid json error located at bad.json, have %v", err)
+	}
+
+	feature.RegisterFormat(feature.Format{
+		Name:       "lines",
+		Extensions: []string{".lines"},
+		ToYaml: func(in []byte) ([]byte, error) {
+			var out []string
+			for _, l := range strings.Split(strings.TrimSpace(string(in)), "\n") {
+				spl := strings.SplitN(l, "=", 2)
+				out = append(out, fmt.Sprintf("- tag: %s\n  values: [%s]", spl[0], spl[1]))
+			}
+			return []byte(strings.Join(out, "\n")), nil
+		},
+	})
+	t.Cleanup(func() { feature.UnregisterFormat("lines") })
+	if !feature.DefinitionFile("more.lines") {
+		t.Error("a registered format was not a definition file")
+	}
+	rfs, err = feature.ParseFeatures("f.lines", []byte("one=a\ntwo=b\n"))
+	if err != nil || len(rfs) != 2 || rfs[1].Tag != "two" {
+		t.Errorf("a document of a registered format was not parsed: %v %v", rfs, err)
+	}
+}
@@ -0,0 +1,131 @@
+package feature
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// A format definition documents may be written in, converted to yaml to be
+// parsed into RawFeature, RawComponent, RawEntity and RawPatch alike. A
+// document is of the format registered for its extension or, for a document
+// without one, as inline, the first format whose Sniff accepts it, otherwise
+// yaml. Other formats, e.g. TOML, are added with RegisterFormat, as a
+// ToYaml decoding the format and marshalling the result as yaml.
+type Format struct {
+	Name       string
+	Extensions []string
+	Sniff      func([]byte) bool
+	ToYaml     func([]byte) ([]byte, error)
+}
+
+var (
+	FormatError = xrr.Xrror("invalid %s document: %s").Out
+
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+// The yaml Format, documents being parsed as they are.
+var YamlFormat = Format{
+	Name:       "yaml",
+	Extensions: []string{".yaml", ".yml"},
+	ToYaml:     func(in []byte) ([]byte, error) { return in, nil },
+}
+
+// The json Format. Json is parsed as the yaml it is a subset of, keeping the
+// lines and columns of what it defines, once validated as json.
+var JsonFormat = Format{
+	Name:       "json",
+	Extensions: []string{".json"},
+	Sniff: func(in []byte) bool {
+		in = bytes.TrimSpace(in)
+		return len(in) > 0 && (in[0] == '{' || in[0] == '[') && json.Valid(in)
+	},
+	ToYaml: func(in []byte) ([]byte, error) {
+		var v interface{}
+		if err := json.Unmarshal(in, &v); err != nil {
+			return nil, FormatError("json", err)
+		}
+		return in, nil
+	},
+}
+
+func init() {
+	RegisterFormat(YamlFormat, JsonFormat)
+}
+
+// Registers each Format, replacing any registered with the same name.
+func RegisterFormat(fs ...Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for _, f := range fs {
+		replaced := false
+		for i, have := range formats {
+			if have.Name == f.Name {
+				formats[i], replaced = f, true
+			}
+		}
+		if !replaced {
+			formats = append(formats, f)
+		}
+	}
+}
+
+// Returns the Format registered for the extension of the provided file, if any.
+func formatOfFile(file string) (Format, bool) {
+	ext := strings.ToLower(filepath.Ext(file))
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		for _, e := range f.Extensions {
+			if ext != "" && strings.ToLower(e) == ext {
+				return f, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// Returns the Format of a document, by the extension of its file, or what it
+// holds.
+func FormatOf(file string, in []byte) Format {
+	if f, ok := formatOfFile(file); ok {
+		return f
+	}
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.Sniff != nil && f.Sniff(in) {
+			return f
+		}
+	}
+	return YamlFormat
+}
+
+// Whether a file found in a directory is a definition document, by its
+// extension.
+func DefinitionFile(file string) bool {
+	_, ok := formatOfFile(file)
+	return ok
+}
+
+// Parses a definition document of any Format into a yaml document node,
+// errors located within the provided file.
+func parseDocument(file string, in []byte) (*yaml.Node, error) {
+	in, err := FormatOf(file, in).ToYaml(in)
+	if err != nil {
+		return nil, Locate(Source{File: file}, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, yamlErrors(file, nil, err)
+	}
+	return &doc, nil
+}
@@ -0,0 +1,199 @@
+package feature
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// How a feature, component or entity is loaded when one with the same tag
+// already exists.
+type LoadMode string
+
+const (
+	// Returns an error for a tag already defined, though a feature defined
+	// again the same way, as by several components, is not an error.
+	LoadError LoadMode = "error"
+	// Keeps what already exists, ignoring the new definition.
+	LoadSkip LoadMode = "skip"
+	// Replaces what already exists with the new definition.
+	LoadReplace LoadMode = "replace"
+	// Appends the values of the new definition missing from what already
+	// exists, e.g. the features of a component, and replaces it with the
+	// result.
+	LoadMerge LoadMode = "merge-values"
+)
+
+var LoadModeError = xrr.Xrror("unknown load mode %s, expected error, skip, replace or merge-values").Out
+
+// Returns the LoadMode named by the provided string, LoadError if empty.
+func ParseLoadMode(s string) (LoadMode, error) {
+	switch m := LoadMode(strings.ToLower(s)); m {
+	case "":
+		return LoadError, nil
+	case LoadError, LoadSkip, LoadReplace, LoadMerge:
+		return m, nil
+	}
+	return "", LoadModeError(s)
+}
+
+type loadModeKey struct{}
+
+// Returns a context loading under the provided LoadMode whatever a definition
+// does not set a LoadMode for itself.
+func WithLoadMode(ctx context.Context, m LoadMode) context.Context {
+	return context.WithValue(ctx, loadModeKey{}, m)
+}
+
+// Returns the LoadMode of a definition, the LoadMode of the provided context
+// if it has none, or LoadError.
+func LoadModeOf(ctx context.Context, m LoadMode) LoadMode {
+	if m != "" {
+		return m
+	}
+	if cm, ok := ctx.Value(loadModeKey{}).(LoadMode); ok && cm != "" {
+		return cm
+	}
+	return LoadError
+}
+
+// Appends to a the values of b it does not hold.
+func mergeValues(a, b []string) []string {
+	ret := append([]string{}, a...)
+	has := make(map[string]bool)
+	for _, v := range a {
+		has[v] = true
+	}
+	for _, v := range b {
+		if !has[v] {
+			ret = append(ret, v)
+			has[v] = true
+		}
+	}
+	return ret
+}
+
+// The definition a Feature was constructed from, or for a feature added
+// without constructing, as by a plugin, what it reports of itself.
+func definitionOf(f Feature) RawFeature {
+	if def, ok := DefinitionOf(f); ok {
+		return def
+	}
+	return f.RawFeature()
+}
+
+// Whether a feature constructed from def refers to the tag or any group.
+func refersTo(e CEnv, def RawFeature, tag string, groups []string) bool {
+	c, ok := e.GetConstructor(def.Apply)
+	if !ok {
+		return false
+	}
+	for _, ref := range References(c, &def) {
+		if strings.EqualFold(ref, tag) {
+			return true
+		}
+		for _, g := range groups {
+			if g != "" && ref == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Replaces the feature of the same tag as the RawFeature, if any, with one
+// constructed from it, merging the values and groups of the replaced feature
+// first under LoadMerge. Features derived from the replaced feature are
+// removed, and features referring to it constructed again from their own
+// definitions, so none keep its old values. Nothing is replaced if
+// constructing the new feature fails.
+func (fs *features) ReplaceFeature(rf *RawFeature, m LoadMode) error {
+	KEY := strings.ToUpper(rf.Tag)
+	old, err := fs.replace(KEY, rf, m)
+	if err != nil {
+		return err
+	}
+	return fs.refresh(KEY, mergeValues(old.Group, rf.Group))
+}
+
+func (fs *features) derived(KEY string) []string {
+	var ret []string
+	for k, f := range fs.has {
+		if src, ok := SourceOf(f); ok && src.Parent == KEY {
+			ret = append(ret, k)
+		}
+	}
+	return ret
+}
+
+// Replaces a feature without constructing again what refers to it, returning
+// the definition replaced.
+func (fs *features) replace(KEY string, rf *RawFeature, m LoadMode) (RawFeature, error) {
+	prev, exists := fs.has[KEY]
+	if !exists {
+		return RawFeature{}, fs.SetFeature(rf)
+	}
+	old := definitionOf(prev)
+	if m == LoadMerge {
+		if rf.Apply == "" {
+			rf.Apply, rf.Constructor = old.Apply, nil
+		}
+		rf.Values = mergeValues(old.Values, rf.Values)
+		rf.Group = mergeValues(old.Group, rf.Group)
+	}
+
+	removed := map[string]Feature{KEY: prev}
+	for _, k := range fs.derived(KEY) {
+		removed[k] = fs.has[k]
+	}
+	for k := range removed {
+		delete(fs.has, k)
+	}
+	if err := fs.SetFeature(rf); err != nil {
+		// anything derived before construction failed goes with it
+		for _, k := range fs.derived(KEY) {
+			delete(fs.has, k)
+		}
+		for k, f := range removed {
+			fs.has[k] = f
+		}
+		return old, err
+	}
+	return old, nil
+}
+
+// Constructs again every feature referring to the tag or any of the groups,
+// and in turn every feature referring to those, returning every error.
+func (fs *features) refresh(tag string, groups []string) error {
+	var errs Errors
+	type ref struct {
+		tag    string
+		groups []string
+	}
+	done := map[string]bool{tag: true}
+	next := []ref{{tag, groups}}
+	for len(next) > 0 {
+		r := next[0]
+		next = next[1:]
+		var defs []RawFeature
+		for k, f := range fs.has {
+			def, ok := DefinitionOf(f)
+			if done[k] || !ok || def.Source.Parent != "" || !refersTo(fs.e, def, r.tag, r.groups) {
+				continue
+			}
+			done[k] = true
+			defs = append(defs, def)
+		}
+		for _, def := range defs {
+			rf := def
+			KEY := strings.ToUpper(rf.Tag)
+			if _, err := fs.replace(KEY, &rf, LoadReplace); err != nil {
+				errs.Add(Locate(def.Source, err))
+				continue
+			}
+			next = append(next, ref{KEY, def.Group})
+		}
+	}
+	return errs.Err()
+}
@@ -0,0 +1,209 @@
+package feature
+
+import (
+	"strings"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// A change to a feature, component or entity already defined, targeted by
+// tag. Append and Remove change the values of a feature, the features of a
+// component or the components of an entity. For a feature only, Weights
+// replace every value after the first, as the weighted constructors take the
+// feature weighted then its weights, Apply changes the constructor and Groups
+// adds groups.
+type RawPatch struct {
+	Feature   string
+	Component string
+	Entity    string
+	Append    []string
+	Remove    []string
+	Weights   []string
+	Apply     string
+	Groups    []string
+	Source    Source `yaml:"-"`
+}
+
+var (
+	PatchTargetError  = xrr.Xrror("a patch targets exactly one feature, component or entity").Out
+	PatchFieldError   = xrr.Xrror("a patch of %s %s cannot change %s").Out
+	PatchValueError   = xrr.Xrror("%s %s has no %s to remove").Out
+	PatchDerivedError = xrr.Xrror("feature %s is derived from %s, patch %s instead").Out
+	PatchWeightsError = xrr.Xrror("feature %s has no values to weight").Out
+)
+
+// The kind, and tag, of what a RawPatch targets.
+func (p *RawPatch) Target() (string, string, error) {
+	var kind, tag string
+	for k, t := range map[string]string{
+		"feature":   strings.ToUpper(p.Feature),
+		"component": p.Component,
+		"entity":    p.Entity,
+	} {
+		if t == "" {
+			continue
+		}
+		if kind != "" {
+			return "", "", PatchTargetError()
+		}
+		kind, tag = k, t
+	}
+	if kind == "" {
+		return "", "", PatchTargetError()
+	}
+	return kind, tag, nil
+}
+
+// Returns have without each value to remove, an error for any it does not hold.
+func patchValues(kind, tag string, have, remove []string) ([]string, error) {
+	var errs Errors
+	drop := make(map[string]bool)
+	for _, r := range remove {
+		found := false
+		for _, v := range have {
+			found = found || v == r
+		}
+		if !found {
+			errs.Add(PatchValueError(kind, tag, r))
+		}
+		drop[r] = true
+	}
+	var ret []string
+	for _, v := range have {
+		if !drop[v] {
+			ret = append(ret, v)
+		}
+	}
+	return ret, errs.Err()
+}
+
+// Applies a RawPatch to what it targets in the provided CEnv, validating it
+// against the current definition first. Nothing is changed for a patch
+// returning an error. A patched feature is constructed again, along with
+// every feature referring to it.
+func ApplyPatch(e CEnv, p *RawPatch) error {
+	kind, tag, err := p.Target()
+	if err == nil {
+		switch kind {
+		case "feature":
+			err = patchFeature(e, tag, p)
+		case "component":
+			err = patchComponent(e, tag, p)
+		case "entity":
+			err = patchEntity(e, tag, p)
+		}
+	}
+	return Locate(p.Source, err)
+}
+
+func featureFields(kind, tag string, p *RawPatch) error {
+	var errs Errors
+	if len(p.Weights) > 0 {
+		errs.Add(PatchFieldError(kind, tag, "weights"))
+	}
+	if p.Apply != "" {
+		errs.Add(PatchFieldError(kind, tag, "apply"))
+	}
+	if len(p.Groups) > 0 {
+		errs.Add(PatchFieldError(kind, tag, "groups"))
+	}
+	return errs.Err()
+}
+
+func patchFeature(e CEnv, TAG string, p *RawPatch) error {
+	f := e.GetFeature(TAG)
+	if f == nil {
+		return NotFoundError("feature", TAG)
+	}
+	def := definitionOf(f)
+	if def.Source.Parent != "" {
+		return PatchDerivedError(TAG, def.Source.Parent, def.Source.Parent)
+	}
+	values, err := patchValues("feature", TAG, def.Values, p.Remove)
+	if err != nil {
+		return err
+	}
+	values = append(values, p.Append...)
+	if len(p.Weights) > 0 {
+		if len(values) == 0 {
+			return PatchWeightsError(TAG)
+		}
+		values = append(values[:1:1], p.Weights...)
+	}
+	if p.Apply != "" {
+		if _, ok := e.GetConstructor(p.Apply); !ok {
+			return NoConstructorError(p.Apply)
+		}
+		def.Apply = p.Apply
+	}
+	def.Values = values
+	def.Group = mergeValues(def.Group, p.Groups)
+	def.Constructor = nil
+	return e.ReplaceFeature(&def, LoadReplace)
+}
+
+func patchComponent(e CEnv, tag string, p *RawPatch) error {
+	if err := featureFields("component", tag, p); err != nil {
+		return err
+	}
+	c := FindComponent(e, tag)
+	if c == nil {
+		return NotFoundError("component", tag)
+	}
+	features, err := patchValues("component", tag, c.Features(), p.Remove)
+	if err != nil {
+		return err
+	}
+	var errs Errors
+	for _, a := range p.Append {
+		if e.GetFeature(a) == nil {
+			errs.Add(NotFoundError("feature", strings.ToUpper(a)))
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	src, _ := SourceOf(c)
+	return e.SetRawComponent(&RawComponent{
+		Tag:      tag,
+		Defines:  ReferenceTags(c.Defines()),
+		Features: ReferenceTags(mergeValues(features, p.Append)),
+		Source:   src,
+		Load:     LoadReplace,
+	})
+}
+
+func patchEntity(e CEnv, tag string, p *RawPatch) error {
+	if err := featureFields("entity", tag, p); err != nil {
+		return err
+	}
+	en := FindEntity(e, tag)
+	if en == nil {
+		return NotFoundError("entity", tag)
+	}
+	components, err := patchValues("entity", tag, en.Components(), p.Remove)
+	if err != nil {
+		return err
+	}
+	var errs Errors
+	for _, a := range p.Append {
+		if FindComponent(e, a) == nil {
+			errs.Add(NotFoundError("component", a))
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	var rcs []*RawComponent
+	for _, c := range mergeValues(components, p.Append) {
+		rcs = append(rcs, &RawComponent{Tag: c})
+	}
+	src, _ := SourceOf(en)
+	return e.SetRawEntity(&RawEntity{
+		Tag:        tag,
+		Defines:    ReferenceTags(en.Defines()),
+		Components: rcs,
+		Source:     src,
+		Load:       LoadReplace,
+	})
+}
@@ -1,11 +1,11 @@
 package feature
 
 import (
-	"log"
+	"context"
 	"sort"
+	"strings"
 
 	"github.com/Laughs-In-Flowers/xrr"
-	"gopkg.in/yaml.v2"
 )
 
 type RawFeature struct {
@@ -13,34 +13,63 @@ type RawFeature struct {
 	Tag         string
 	Apply       string
 	Values      []string
-	Constructor Constructor
+	Extends     string      `yaml:",omitempty"`
+	Abstract    bool        `yaml:",omitempty"`
+	Constructor Constructor `yaml:"-"`
+	Source      Source      `yaml:"-"`
+	Load        LoadMode    `yaml:"-"`
+	ctx         context.Context
 }
 
+// Returns the context this RawFeature is constructed under, defaulting to
+// context.Background.
+func (r *RawFeature) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// Sets the context this RawFeature is constructed under, allowing long running
+// constructors to stop when the context is done.
+func (r *RawFeature) WithContext(ctx context.Context) *RawFeature {
+	r.ctx = ctx
+	return r
+}
+
+var NoValuesError = xrr.Xrror("zero length list for %s").Out
+
+// Returns the values of the RawFeature, panicking if there are none. Called
+// from within a constructor, the panic is returned as a construction error.
 func (r *RawFeature) MustGetValues() []string {
 	list := r.Values
 	if len(list) < 1 {
-		log.Fatalf("zero length list for %s", r.Tag)
+		panic(NoValuesError(r.Tag))
 	}
 	return list
 }
 
 type Raw interface {
 	Queue([]byte) error
-	Dequeue(...string)
+	Dequeue(context.Context, ...string) error
 	//DeqComponent([]*RawComponent) error
 	//DeqEntity([]*RawEntity) error
 	AddRaw(...*RawFeature) error
+	ListTemplates() []RawFeature
 }
 
 type raw struct {
-	e   CEnv
-	has []*RawFeature
+	e         CEnv
+	has       []*RawFeature
+	refs      []*RawFeature
+	templates map[string]*RawFeature
 }
 
 func NewRaw(e CEnv) *raw {
 	return &raw{
-		e:   e,
-		has: make([]*RawFeature, 0),
+		e:         e,
+		has:       make([]*RawFeature, 0),
+		templates: make(map[string]*RawFeature),
 	}
 }
 
@@ -71,38 +100,188 @@ func applyConstructor(e CEnv, rf *RawFeature) error {
 	return nil
 }
 
+// Whether a RawFeature only names a feature, with no apply, values or
+// feature it extends, as a component commonly names a feature defined
+// elsewhere.
+func (rf *RawFeature) Reference() bool {
+	return rf.Apply == "" && len(rf.Values) == 0 && rf.Extends == "" && !rf.Abstract
+}
+
+// Returns a RawFeature only naming each of the provided tags, see Reference.
+func ReferenceTags(tags []string) []*RawFeature {
+	var ret []*RawFeature
+	for _, t := range tags {
+		ret = append(ret, &RawFeature{Tag: t})
+	}
+	return ret
+}
+
+var (
+	ExtendsError      = xrr.Xrror("feature %s extends %s, which is not defined").Out
+	ExtendsCycleError = xrr.Xrror("feature %s extends itself through %s").Out
+)
+
+// Gives a RawFeature the apply and values of the feature it extends, where it
+// sets none itself, and its groups along with its own.
+func inherit(rf, base *RawFeature) {
+	if rf.Apply == "" {
+		rf.Apply = base.Apply
+	}
+	if len(rf.Values) == 0 {
+		rf.Values = append([]string{}, base.Values...)
+	}
+	rf.Group = mergeValues(base.Group, rf.Group)
+	rf.Extends = ""
+}
+
+// The feature a RawFeature may extend, from those added with it, the
+// templates, those queued, or those already set, in that order.
+func (r *raw) base(added map[string]*RawFeature, BASE string) (*RawFeature, bool) {
+	if b, ok := added[BASE]; ok {
+		return b, true
+	}
+	if b, ok := r.templates[BASE]; ok {
+		return b, true
+	}
+	for _, q := range r.has {
+		if strings.ToUpper(q.Tag) == BASE {
+			return q, true
+		}
+	}
+	if f := r.e.GetFeature(BASE); f != nil {
+		def := definitionOf(f)
+		return &def, true
+	}
+	return nil, false
+}
+
+// Resolves what a RawFeature extends, and in turn what that extends.
+func (r *raw) resolve(added map[string]*RawFeature, rf *RawFeature, through []string) error {
+	if rf.Extends == "" {
+		return nil
+	}
+	TAG, BASE := strings.ToUpper(rf.Tag), strings.ToUpper(rf.Extends)
+	through = append(through, TAG)
+	for _, t := range through[:len(through)-1] {
+		if t == TAG {
+			return ExtendsCycleError(through[0], strings.Join(through[1:], ", "))
+		}
+	}
+	base, ok := r.base(added, BASE)
+	if !ok {
+		return ExtendsError(TAG, BASE)
+	}
+	if err := r.resolve(added, base, through); err != nil {
+		return err
+	}
+	inherit(rf, base)
+	return nil
+}
+
+// Queues each RawFeature to be set, resolving what each extends first.
+// Abstract features are kept as templates to extend, and never set. Every
+// error is returned.
 func (r *raw) AddRaw(rfs ...*RawFeature) error {
+	added := make(map[string]*RawFeature)
 	for _, rf := range rfs {
-		err := applyConstructor(r.e, rf)
-		if err != nil {
-			return err
+		TAG := strings.ToUpper(rf.Tag)
+		if _, ok := added[TAG]; !ok && !rf.Reference() {
+			added[TAG] = rf
+		}
+	}
+	var errs Errors
+	for _, rf := range rfs {
+		if err := r.resolve(added, rf, nil); err != nil {
+			errs.Add(Locate(rf.Source, err))
+			continue
+		}
+		switch {
+		case rf.Abstract:
+			r.templates[strings.ToUpper(rf.Tag)] = rf
+			continue
+		case rf.Reference():
+			r.refs = append(r.refs, rf)
+			continue
+		}
+		if err := applyConstructor(r.e, rf); err != nil {
+			errs.Add(Locate(rf.Source, err))
+			continue
 		}
 		r.has = append(r.has, rf)
 	}
-	return nil
+	return errs.Err()
+}
+
+// Lists the abstract features kept to extend.
+func (r *raw) ListTemplates() []RawFeature {
+	var ret []RawFeature
+	for _, t := range r.templates {
+		ret = append(ret, *t)
+	}
+	return ret
 }
 
 func (r *raw) Queue(in []byte) error {
-	var rfs []*RawFeature
-	err := yaml.Unmarshal(in, &rfs)
+	rfs, err := ParseFeatures("", in)
 	if err != nil {
 		return err
 	}
 	return r.AddRaw(rfs...)
 }
 
-func (r *raw) Dequeue(groups ...string) {
+var ConflictError = xrr.Xrror("feature %s is already defined differently").Out
+
+// Whether an existing Feature was defined with the same constructor and values
+// as a RawFeature.
+func sameDefinition(f Feature, rf *RawFeature) bool {
+	apply := strings.EqualFold(f.From(), rf.Apply)
+	if rf.Constructor != nil {
+		apply = apply || strings.EqualFold(f.From(), rf.Constructor.Tag())
+	}
+	return apply && f.Raw() == strings.Join(rf.Values, ",")
+}
+
+func (r *raw) Dequeue(ctx context.Context, groups ...string) error {
 	sort.Sort(r)
+	var errs Errors
 	for i, rf := range r.has {
+		if ctx.Err() != nil {
+			break
+		}
+		if f := r.e.GetFeature(rf.Tag); f != nil {
+			// the same feature is commonly defined by several components
+			switch m := LoadModeOf(ctx, rf.Load); {
+			case sameDefinition(f, rf), m == LoadSkip:
+			case m == LoadReplace, m == LoadMerge:
+				rf.Group = append(rf.Group, groups...)
+				errs.Add(Locate(rf.Source, r.e.ReplaceFeature(rf.WithContext(ctx), m)))
+			default:
+				errs.Add(Locate(rf.Source, ConflictError(strings.ToUpper(rf.Tag))))
+			}
+			r.has[i] = nil
+			continue
+		}
 		rf.Group = append(rf.Group, groups...)
-		r.e.SetFeature(rf)
+		errs.Add(Locate(rf.Source, r.e.SetFeature(rf.WithContext(ctx))))
 		r.has[i] = nil
 	}
 	r.has = nil
+	// a reference must name a feature defined by now
+	for _, rf := range r.refs {
+		if ctx.Err() == nil && r.e.GetFeature(rf.Tag) == nil {
+			errs.Add(Locate(rf.Source, NotFoundError("feature", strings.ToUpper(rf.Tag))))
+		}
+	}
+	r.refs = nil
+	errs.Add(ctx.Err())
+	return errs.Err()
 }
 
-func DeqComponent(e CEnv, rcs []*RawComponent) error {
+// Adds the features of each component to be dequeued, and sets each component
+// under its LoadMode, or that of the provided context.
+func DeqComponent(ctx context.Context, e CEnv, rcs []*RawComponent) error {
 	for _, rc := range rcs {
+		rc.Load = LoadModeOf(ctx, rc.Load)
 		var fs []*RawFeature
 		fs = append(fs, rc.Defines...)
 		fs = append(fs, rc.Features...)
@@ -114,13 +293,25 @@ func DeqComponent(e CEnv, rcs []*RawComponent) error {
 	return e.SetRawComponent(rcs...)
 }
 
-func DeqEntity(e CEnv, res []*RawEntity) error {
+// Adds the features and components of each entity to be dequeued, and sets
+// each entity under its LoadMode, or that of the provided context.
+func DeqEntity(ctx context.Context, e CEnv, res []*RawEntity) error {
 	for _, re := range res {
+		re.Load = LoadModeOf(ctx, re.Load)
 		err := e.AddRaw(re.Defines...)
 		if err != nil {
 			return err
 		}
-		err = DeqComponent(e, re.Components)
+		// a component only named, with no defines or features, refers to one
+		// already set, as a feature only named does, rather than redefining it
+		var rcs []*RawComponent
+		for _, rc := range re.Components {
+			if len(rc.Defines)+len(rc.Features) == 0 && FindComponent(e, rc.Tag) != nil {
+				continue
+			}
+			rcs = append(rcs, rc)
+		}
+		err = DeqComponent(ctx, e, rcs)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,399 @@
+package feature
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// Where a feature, component or entity was defined: a line of a yaml file, a
+// plugin, or for a feature derived by a constructor, the parent feature.
+type Source struct {
+	File   string
+	Line   int
+	Column int
+	Plugin string
+	Parent string
+}
+
+func (s Source) String() string {
+	var at string
+	switch {
+	case s.Plugin != "":
+		at = "plugin " + s.Plugin
+	case s.File != "" && s.Line > 0 && s.Column > 0:
+		at = fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Column)
+	case s.File != "" && s.Line > 0:
+		at = fmt.Sprintf("%s:%d", s.File, s.Line)
+	case s.File != "":
+		at = s.File
+	}
+	if s.Parent != "" {
+		if at == "" {
+			return "derived from " + s.Parent
+		}
+		return fmt.Sprintf("derived from %s at %s", s.Parent, at)
+	}
+	return at
+}
+
+// Returns the Source of a feature derived from the provided RawFeature.
+func DerivedSource(r *RawFeature) Source {
+	s := r.Source
+	s.Parent = strings.ToUpper(r.Tag)
+	return s
+}
+
+// Anything able to report where it was defined.
+type Sourced interface {
+	Source() Source
+}
+
+// Returns the Source of a Feature, Component or Entity, if it has one.
+func SourceOf(i interface{}) (Source, bool) {
+	if s, ok := i.(Sourced); ok {
+		src := s.Source()
+		return src, src != Source{}
+	}
+	return Source{}, false
+}
+
+// A Feature with where, and from what definition, it was loaded.
+type loadedFeature struct {
+	Feature
+	source Source
+	def    *RawFeature
+}
+
+func (l loadedFeature) Source() Source {
+	return l.source
+}
+
+func (l loadedFeature) RawFeature() RawFeature {
+	rf := l.Feature.RawFeature()
+	rf.Source = l.source
+	return rf
+}
+
+// Returns the provided Feature reporting the provided Source.
+func WithSource(f Feature, s Source) Feature {
+	if lf, ok := f.(loadedFeature); ok {
+		lf.source = s
+		return lf
+	}
+	return loadedFeature{Feature: f, source: s}
+}
+
+// An error located at a Source, reported as a compiler reports diagnostics.
+type SourceError struct {
+	Source Source
+	Err    error
+}
+
+func (e SourceError) Error() string {
+	if at := e.Source.String(); at != "" {
+		return fmt.Sprintf("%s: %s", at, e.Err)
+	}
+	return e.Err.Error()
+}
+
+// Locates an error at the provided Source, returning nil for a nil error and
+// the error unchanged if the Source is empty or the error is already located.
+// Each of multiple Errors is located.
+func Locate(s Source, err error) error {
+	switch et := err.(type) {
+	case nil:
+		return nil
+	case SourceError:
+		return err
+	case Errors:
+		var ret Errors
+		for _, e := range et {
+			ret.Add(Locate(s, e))
+		}
+		return ret.Err()
+	}
+	if s == (Source{}) {
+		return err
+	}
+	return SourceError{s, err}
+}
+
+var (
+	NotSequenceError = xrr.Xrror("expected a list of %s").Out
+	yamlLine         = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+)
+
+// Returns a yaml error message located at its line within the provided file,
+// and at the column of the first node on that line within n, if any.
+func yamlError(file string, n *yaml.Node, msg string) error {
+	m := yamlLine.FindStringSubmatch(msg)
+	if m == nil {
+		return Locate(Source{File: file}, errors.New(msg))
+	}
+	line, _ := strconv.Atoi(m[1])
+	return Locate(Source{File: file, Line: line, Column: columnAt(n, line)}, errors.New(m[2]))
+}
+
+func columnAt(n *yaml.Node, line int) int {
+	if n == nil {
+		return 0
+	}
+	if n.Line == line {
+		return n.Column
+	}
+	for _, c := range n.Content {
+		if col := columnAt(c, line); col > 0 {
+			return col
+		}
+	}
+	return 0
+}
+
+func yamlErrors(file string, n *yaml.Node, err error) error {
+	var errs Errors
+	switch et := err.(type) {
+	case *yaml.TypeError:
+		for _, msg := range et.Errors {
+			errs.Add(yamlError(file, n, msg))
+		}
+	default:
+		errs.Add(yamlError(file, n, err.Error()))
+	}
+	return errs.Err()
+}
+
+func nodeSource(file string, n *yaml.Node) Source {
+	if file == "" {
+		return Source{}
+	}
+	return Source{File: file, Line: n.Line, Column: n.Column}
+}
+
+// Parses the top level sequence of a document of any Format, returning nil
+// for an empty document. The sequence may instead be held under the named kind of a
+// mapping, along with the LoadMode of everything it defines under load, e.g.
+//
+//	load: replace
+//	features:
+//	- tag: one
+//	  values: [a, b]
+func sequence(file, kind string, in []byte) (*yaml.Node, LoadMode, error) {
+	doc, err := parseDocument(file, in)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(doc.Content) == 0 {
+		return nil, "", nil
+	}
+	n := doc.Content[0]
+	switch {
+	case n.Kind == yaml.SequenceNode:
+		return n, "", nil
+	case n.Kind == yaml.ScalarNode && n.Tag == "!!null":
+		return nil, "", nil
+	case n.Kind == yaml.MappingNode:
+		if seq := field(n, kind); seq != nil && seq.Kind == yaml.SequenceNode {
+			m, err := documentLoadMode(file, n)
+			return seq, m, err
+		}
+		// a document may only include others
+		if field(n, "include") != nil {
+			return nil, "", nil
+		}
+	}
+	return nil, "", Locate(nodeSource(file, n), NotSequenceError(kind))
+}
+
+// Parses the files a yaml document includes, as written under include of a
+// mapping document, either a path or glob pattern or a list of them, e.g.
+//
+//	include:
+//	- common.yaml
+//	- weapons/*.yaml
+//	features:
+//	- tag: one
+//	  values: [a, b]
+func ParseIncludes(file string, in []byte) ([]string, error) {
+	doc, err := parseDocument(file, in)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	n := field(doc.Content[0], "include")
+	if n == nil {
+		return nil, nil
+	}
+	var ret []string
+	switch n.Kind {
+	case yaml.ScalarNode:
+		ret = append(ret, n.Value)
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, Locate(nodeSource(file, item), NotSequenceError("files to include"))
+			}
+			ret = append(ret, item.Value)
+		}
+	default:
+		return nil, Locate(nodeSource(file, n), NotSequenceError("files to include"))
+	}
+	return ret, nil
+}
+
+// The LoadMode a mapping document sets under load, if any.
+func documentLoadMode(file string, n *yaml.Node) (LoadMode, error) {
+	v := field(n, "load")
+	if v == nil {
+		return "", nil
+	}
+	m, err := ParseLoadMode(v.Value)
+	if err != nil {
+		return "", Locate(nodeSource(file, v), err)
+	}
+	return m, nil
+}
+
+// The value node of the named key of a mapping node, of any kind.
+func field(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func decodeFeatures(file string, m LoadMode, n *yaml.Node) ([]*RawFeature, error) {
+	var errs Errors
+	var ret []*RawFeature
+	for _, item := range n.Content {
+		rf := &RawFeature{}
+		if err := item.Decode(rf); err != nil {
+			errs.Add(yamlErrors(file, item, err))
+			continue
+		}
+		rf.Source = nodeSource(file, item)
+		rf.Load = m
+		ret = append(ret, rf)
+	}
+	return ret, errs.Err()
+}
+
+// Decodes a component, and the features it defines, from a mapping node.
+func decodeComponent(file string, m LoadMode, item *yaml.Node) (*RawComponent, error) {
+	var errs Errors
+	rc := &RawComponent{}
+	if err := item.Decode(rc); err != nil {
+		return nil, yamlErrors(file, item, err)
+	}
+	rc.Source = nodeSource(file, item)
+	rc.Load = m
+	if d := field(item, "defines"); d != nil && d.Kind == yaml.SequenceNode {
+		var err error
+		rc.Defines, err = decodeFeatures(file, m, d)
+		errs.Add(err)
+	}
+	if f := field(item, "features"); f != nil && f.Kind == yaml.SequenceNode {
+		var err error
+		rc.Features, err = decodeFeatures(file, m, f)
+		errs.Add(err)
+	}
+	return rc, errs.Err()
+}
+
+func decodeComponents(file string, m LoadMode, n *yaml.Node) ([]*RawComponent, error) {
+	var errs Errors
+	var ret []*RawComponent
+	for _, item := range n.Content {
+		rc, err := decodeComponent(file, m, item)
+		errs.Add(err)
+		if rc != nil {
+			ret = append(ret, rc)
+		}
+	}
+	return ret, errs.Err()
+}
+
+// Parses yaml into RawFeature, locating each, and any error, within the
+// provided file. Every error found is returned.
+func ParseFeatures(file string, in []byte) ([]*RawFeature, error) {
+	n, m, err := sequence(file, "features", in)
+	if n == nil || err != nil {
+		return nil, err
+	}
+	return decodeFeatures(file, m, n)
+}
+
+// Parses yaml into RawComponent, locating each, the features each defines, and
+// any error within the provided file. Every error found is returned.
+func ParseComponents(file string, in []byte) ([]*RawComponent, error) {
+	n, m, err := sequence(file, "components", in)
+	if n == nil || err != nil {
+		return nil, err
+	}
+	return decodeComponents(file, m, n)
+}
+
+// Parses yaml into RawEntity, locating each, the features and components each
+// defines, and any error within the provided file. Every error found is
+// returned.
+func ParseEntities(file string, in []byte) ([]*RawEntity, error) {
+	n, m, err := sequence(file, "entities", in)
+	if n == nil || err != nil {
+		return nil, err
+	}
+	var errs Errors
+	var ret []*RawEntity
+	for _, item := range n.Content {
+		re := &RawEntity{}
+		if err := item.Decode(re); err != nil {
+			errs.Add(yamlErrors(file, item, err))
+			continue
+		}
+		re.Source = nodeSource(file, item)
+		re.Load = m
+		if d := field(item, "defines"); d != nil && d.Kind == yaml.SequenceNode {
+			re.Defines, err = decodeFeatures(file, m, d)
+			errs.Add(err)
+		}
+		if c := field(item, "components"); c != nil && c.Kind == yaml.SequenceNode {
+			re.Components, err = decodeComponents(file, m, c)
+			errs.Add(err)
+		}
+		ret = append(ret, re)
+	}
+	return ret, errs.Err()
+}
+
+// Parses yaml into RawPatch, locating each, and any error, within the provided
+// file. Every error found is returned.
+func ParsePatches(file string, in []byte) ([]*RawPatch, error) {
+	n, _, err := sequence(file, "patches", in)
+	if n == nil || err != nil {
+		return nil, err
+	}
+	var errs Errors
+	var ret []*RawPatch
+	for _, item := range n.Content {
+		p := &RawPatch{}
+		if err := item.Decode(p); err != nil {
+			errs.Add(yamlErrors(file, item, err))
+			continue
+		}
+		p.Source = nodeSource(file, item)
+		ret = append(ret, p)
+	}
+	return ret, errs.Err()
+}
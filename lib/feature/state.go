@@ -0,0 +1,47 @@
+package feature
+
+import (
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// A Feature keeping state between mappings, e.g. the position of a round robin
+// or what remains of a deck, able to save and restore it as a string.
+type Stateful interface {
+	State() string
+	SetState(string) error
+}
+
+var StateError = xrr.Xrror("invalid state %q for %s").Out
+
+type statefulMapper struct {
+	Mapper
+	get func() string
+	set func(string) error
+}
+
+func (s *statefulMapper) State() string {
+	return s.get()
+}
+
+func (s *statefulMapper) SetState(st string) error {
+	return s.set(st)
+}
+
+// Returns a Mapper keeping state, which get and set save and restore.
+func NewStatefulMapper(mfn MapFn, get func() string, set func(string) error) Mapper {
+	return &statefulMapper{NewMapper(mfn), get, set}
+}
+
+// Returns the Stateful of a Feature, if it keeps state.
+func StateOf(f Feature) (Stateful, bool) {
+	switch ft := f.(type) {
+	case loadedFeature:
+		return StateOf(ft.Feature)
+	case *feature:
+		s, ok := ft.Mapper.(Stateful)
+		return s, ok
+	case Stateful:
+		return ft, true
+	}
+	return nil, false
+}
@@ -2,6 +2,7 @@ package feature
 
 import (
 	cr "crypto/rand"
+	"strings"
 
 	"github.com/Laughs-In-Flowers/xrr"
 )
@@ -12,6 +13,38 @@ var (
 	NotFoundError     = xrr.Xrror("%s named %s not found").Out
 )
 
+// A list of errors reported together.
+type Errors []error
+
+// Adds an error, flattening any Errors and ignoring nil.
+func (e *Errors) Add(errs ...error) {
+	for _, err := range errs {
+		switch et := err.(type) {
+		case nil:
+		case Errors:
+			e.Add(et...)
+		default:
+			*e = append(*e, err)
+		}
+	}
+}
+
+func (e Errors) Error() string {
+	var s []string
+	for _, err := range e {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Returns nil if there are no errors, otherwise the Errors.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
 type uuid [16]byte
 
 var halfbyte2hexchar = []byte{
@@ -54,6 +87,11 @@ func v4() (uuid, error) {
 	return u, nil
 }
 
+// Returns a new random identifier, as given to each generated entity.
+func NewID() string {
+	return genUUID()
+}
+
 func genUUID() string {
 	u, err := v4()
 	if err != nil {
@@ -0,0 +1,165 @@
+package feature
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+var (
+	UndefinedVarError = xrr.Xrror("variable %s is not defined").Out
+	VarError          = xrr.Xrror("%s is not a name=value variable").Out
+	VarsError         = xrr.Xrror("vars is not a mapping of names to values").Out
+	varName           = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	// ${name}, or $${name} for the text ${name} itself.
+	varPattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+)
+
+type varsKey struct{}
+
+// Returns a context substituting the provided variables in definition
+// documents, over any the context already holds.
+func WithVars(ctx context.Context, vars map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range VarsOf(ctx) {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, varsKey{}, merged)
+}
+
+// Returns the variables of the provided context, if any.
+func VarsOf(ctx context.Context) map[string]string {
+	if vars, ok := ctx.Value(varsKey{}).(map[string]string); ok {
+		return vars
+	}
+	return nil
+}
+
+// Parses name=value strings into variables, later values of a name replacing
+// earlier.
+func ParseVars(in ...string) (map[string]string, error) {
+	var errs Errors
+	ret := make(map[string]string)
+	for _, s := range in {
+		spl := strings.SplitN(s, "=", 2)
+		name := strings.TrimSpace(spl[0])
+		if len(spl) != 2 || !varName.MatchString(name) {
+			errs.Add(VarError(s))
+			continue
+		}
+		ret[name] = spl[1]
+	}
+	return ret, errs.Err()
+}
+
+// Splits a comma separated list of name=value variables, a comma only
+// separating variables where a name= follows it, so values may hold commas,
+// e.g. "weights=1,2,3,level=hard".
+func SplitVars(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var ret []string
+	for _, part := range strings.Split(s, ",") {
+		spl := strings.SplitN(part, "=", 2)
+		if len(ret) > 0 && (len(spl) != 2 || !varName.MatchString(strings.TrimSpace(spl[0]))) {
+			ret[len(ret)-1] += "," + part
+			continue
+		}
+		ret = append(ret, part)
+	}
+	return ret
+}
+
+// Reads the variables of a file, of any Format, mapping names to values.
+func ReadVarsFile(file string) (map[string]string, error) {
+	in, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseDocument(file, in)
+	if err != nil || len(doc.Content) == 0 {
+		return nil, err
+	}
+	var ret map[string]string
+	if err := doc.Content[0].Decode(&ret); err != nil {
+		return nil, yamlErrors(file, doc.Content[0], err)
+	}
+	return ret, nil
+}
+
+// The variables a mapping document defines under vars, read with every
+// variable in it blanked so a reference where yaml forbids braces does not
+// fail; the document itself is parsed, and any error reported, once
+// substituted.
+func documentVars(file string, in []byte) (map[string]string, error) {
+	doc, err := parseDocument(file, varPattern.ReplaceAll(in, []byte("_")))
+	if err != nil || len(doc.Content) == 0 {
+		return nil, nil
+	}
+	n := field(doc.Content[0], "vars")
+	if n == nil {
+		return nil, nil
+	}
+	var ret map[string]string
+	if err := n.Decode(&ret); err != nil {
+		return nil, Locate(nodeSource(file, n), VarsError())
+	}
+	return ret, nil
+}
+
+// Substitutes each ${name} of a document of any Format with the value of the
+// variable, as held by the provided context or otherwise defined by the
+// document under vars, e.g.
+//
+//	vars:
+//	  difficulty: normal
+//	features:
+//	- tag: enemies
+//	  values: [goblins-${difficulty}]
+//
+// $${name} is kept as the text ${name}. Every variable not defined is an error
+// located at its line within the provided file.
+func ExpandVars(ctx context.Context, file string, in []byte) ([]byte, error) {
+	if !bytes.Contains(in, []byte("${")) {
+		return in, nil
+	}
+	vars, err := documentVars(file, in)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range VarsOf(ctx) {
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars[k] = v
+	}
+
+	var errs Errors
+	lines := bytes.Split(in, []byte("\n"))
+	for i, line := range lines {
+		lines[i] = varPattern.ReplaceAllFunc(line, func(m []byte) []byte {
+			sub := varPattern.FindSubmatch(m)
+			if len(sub[1]) > 0 {
+				return m[1:]
+			}
+			v, ok := vars[string(sub[2])]
+			if !ok {
+				errs.Add(Locate(Source{File: file, Line: i + 1}, UndefinedVarError(string(sub[2]))))
+				return m
+			}
+			return []byte(v)
+		})
+	}
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The credentials of the process making a request.
+type Peer struct {
+	Pid, Uid, Gid int
+}
+
+type peerKey struct{}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// Returns the Peer making the request the context belongs to, if known.
+func PeerFrom(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok && p != nil
+}
+
+var (
+	PeerUnsupportedError = xrr.Xrror("peer credentials are unsupported on this platform")
+	UnauthorizedError    = xrr.Xrror("unauthorized %s request: %s").Out
+)
+
+type access struct {
+	uids   map[int]bool
+	gids   map[int]bool
+	tokens map[string]string
+}
+
+func newAccess() *access {
+	return &access{
+		uids:   make(map[int]bool),
+		gids:   make(map[int]bool),
+		tokens: make(map[string]string),
+	}
+}
+
+func (a *access) restrictsPeers() bool {
+	return len(a.uids) > 0 || len(a.gids) > 0
+}
+
+// A peer is allowed when no allowlist is set, it shares the server uid, or its
+// uid or gid is allowed.
+func (a *access) allowPeer(p *Peer) bool {
+	if !a.restrictsPeers() {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	return p.Uid == os.Getuid() || a.uids[p.Uid] || a.gids[p.Gid]
+}
+
+func (a *access) allowToken(service, token string) bool {
+	want, ok := a.tokens[service]
+	if !ok || want == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(token)) == 1
+}
+
+func (s *Server) authorize(ctx context.Context, r *Request) error {
+	service := r.Service.String()
+	p, _ := PeerFrom(ctx)
+	if !s.access.allowPeer(p) {
+		return UnauthorizedError(service, "peer not allowed")
+	}
+	if !s.access.allowToken(service, r.Token) {
+		return UnauthorizedError(service, "invalid token")
+	}
+	return nil
+}
+
+// Returns a numeric group id for either a group id or group name.
+func lookupGid(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
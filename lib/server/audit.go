@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// A single line of the audit log, recording one mutating request. Uid and pid
+// are -1 when peer credentials are unavailable.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Id      uint64    `json:"id"`
+	Uid     int       `json:"uid"`
+	Pid     int       `json:"pid"`
+	Env     string    `json:"env,omitempty"`
+	Service string    `json:"service"`
+	Action  string    `json:"action"`
+	Groups  []string  `json:"groups,omitempty"`
+	Tags    []string  `json:"tags,omitempty"`
+	Plugins []string  `json:"plugins,omitempty"`
+	Files   []string  `json:"files,omitempty"`
+	Outcome string    `json:"outcome"`
+}
+
+type audit struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openAudit(path string) (*audit, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &audit{f: f}, nil
+}
+
+func (a *audit) write(e *AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+func (a *audit) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+func (s *Server) nextRequestId() uint64 {
+	return atomic.AddUint64(&s.lastId, 1)
+}
+
+func isEnvAction(a Action) bool {
+	return actionIs(a, ENVCREATE) || actionIs(a, ENVCLONE) || actionIs(a, ENVDROP)
+}
+
+func audited(r *Request) bool {
+	switch {
+	case actionIs(r.Action, POPULATEFROMFILES),
+		actionIs(r.Action, POPULATEINLINE),
+		actionIs(r.Action, DEPOPULATE),
+		actionIs(r.Action, WATCH),
+		actionIs(r.Action, QUIT),
+		actionIs(r.Action, RESTORE),
+		actionIs(r.Action, CHECKPOINT),
+		actionIs(r.Action, ROLLBACK),
+		isEnvAction(r.Action):
+		return true
+	}
+	return false
+}
+
+// The tags of features in the provided groups, or of all features when no
+// group is provided.
+func groupTags(e env.Env, groups []string) map[string]bool {
+	ret := make(map[string]bool)
+	var rfs []feature.RawFeature
+	switch {
+	case len(groups) == 0:
+		rfs = e.ListAll()
+	default:
+		for _, g := range groups {
+			rfs = append(rfs, e.List(g)...)
+		}
+	}
+	for _, rf := range rfs {
+		ret[rf.Tag] = true
+	}
+	return ret
+}
+
+// Tags present in only one of before and after, i.e. those a request added or
+// removed.
+func changedTags(before, after map[string]bool) []string {
+	var ret []string
+	for t := range before {
+		if !after[t] {
+			ret = append(ret, t)
+		}
+	}
+	for t := range after {
+		if !before[t] {
+			ret = append(ret, t)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+type auditing struct {
+	entry  *AuditEntry
+	groups []string
+	before map[string]bool
+}
+
+// Begins an audit entry for a mutating request, returning nil if the server
+// keeps no audit log or the request does not mutate the server.
+func (s *Server) beginAudit(ctx context.Context, id uint64, r *Request) *auditing {
+	if s.audit == nil || !audited(r) {
+		return nil
+	}
+	e := &AuditEntry{
+		Id:      id,
+		Uid:     -1,
+		Pid:     -1,
+		Service: r.Service.String(),
+		Action:  r.Action.String(),
+	}
+	if p, ok := PeerFrom(ctx); ok {
+		e.Uid, e.Pid = p.Uid, p.Pid
+	}
+	a := &auditing{entry: e}
+	d := r.Data
+	switch {
+	case d == nil, actionIs(r.Action, QUIT):
+	case isEnvAction(r.Action):
+		e.Env = d.ToString("env_name")
+	default:
+		e.Env = d.ToString("meta.env")
+		a.groups = d.ToStrings("groups")
+		e.Groups = a.groups
+		e.Plugins = append(d.ToStrings("constructor-plugin"), d.ToStrings("feature-plugin")...)
+		for _, k := range []string{"features", "components", "entities"} {
+			e.Files = append(e.Files, d.ToStrings(k)...)
+		}
+		if en, err := s.Environment(e.Env); err == nil {
+			a.before = groupTags(en, a.groups)
+		}
+	}
+	return a
+}
+
+func (s *Server) finishAudit(a *auditing, resp []byte) {
+	if a == nil {
+		return
+	}
+	e := a.entry
+	e.Time = time.Now()
+	// the environment is resolved again as a request may replace it
+	if en, err := s.Environment(e.Env); err == nil && a.before != nil {
+		e.Tags = changedTags(a.before, groupTags(en, a.groups))
+	}
+	e.Outcome = "ok"
+	if rErr := responseError(resp); rErr != "" {
+		e.Outcome = rErr
+	}
+	if err := s.audit.write(e); err != nil {
+		s.Printf("audit log write failed: %s", err)
+	}
+}
+
+// The number of audit log lines a query returns when none is specified, and
+// the most a query may return.
+var (
+	defaultAuditLines = 20
+	maxAuditLines     = 1000
+)
+
+// Returns up to the last n lines of the file at path, reading backwards from
+// the end rather than the whole log.
+func tail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	chunk := int64(4096)
+	for at := fi.Size(); at > 0 && bytes.Count(buf, []byte("\n")) <= n; {
+		if at < chunk {
+			chunk = at
+		}
+		at -= chunk
+		b := make([]byte, chunk)
+		if _, err := f.ReadAt(b, at); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(b, buf...)
+	}
+
+	lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	var ret []string
+	for _, l := range lines {
+		if len(l) > 0 {
+			ret = append(ret, string(l))
+		}
+	}
+	return ret, nil
+}
+
+var NoAuditLogError = xrr.Xrror("no audit log configured")
+
+// Responds with the most recent audit log lines, a query action protected as
+// any other, e.g. by SetServiceToken(token, "query").
+func auditRespond(ctx context.Context, s *Server, r *Request) []byte {
+	if s.AuditLog == "" {
+		return ErrorResponse(NoAuditLogError).ToByte()
+	}
+	resp := EmptyResponse()
+	d := r.Data
+	if d == nil {
+		d = data.New("")
+	}
+	n := d.ToInt("audit_lines")
+	switch {
+	case n <= 0:
+		n = defaultAuditLines
+	case n > maxAuditLines:
+		n = maxAuditLines
+	}
+	lines, err := tail(s.AuditLog, n)
+	if err != nil {
+		return ErrorResponse(err).ToByte()
+	}
+	d.Set(data.NewStringsItem("audit", lines...))
+	resp.Data = d
+	return resp.ToByte()
+}
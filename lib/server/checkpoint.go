@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The name diff accepts for an environment as it currently is.
+const CurrentCheckpoint = "current"
+
+var (
+	CheckpointNameError   = xrr.Xrror("a checkpoint name other than %s is required").Out
+	NoCheckpointError     = xrr.Xrror("no checkpoint named %s in environment %s").Out
+	CheckpointExistsError = xrr.Xrror("a checkpoint named %s already exists in environment %s").Out
+)
+
+type checkpoint struct {
+	name  string
+	e     env.Env
+	state map[string]string
+	taken time.Time
+}
+
+// Checkpoints by name, by environment.
+type checkpoints struct {
+	mu  sync.Mutex
+	has map[string]map[string]*checkpoint
+}
+
+func newCheckpoints() *checkpoints {
+	return &checkpoints{has: make(map[string]map[string]*checkpoint)}
+}
+
+func envName(name string) string {
+	if isDefault(name) {
+		return DefaultEnvironment
+	}
+	return name
+}
+
+// Checkpoints the named environment as it is. A checkpoint shares the
+// environment itself, as every change is staged on a copy that replaces it,
+// keeping only the state of features keeping state, e.g. round robins, which
+// change within the environment when applied.
+func (s *Server) Checkpoint(ctx context.Context, en, name string) error {
+	if name == "" || name == CurrentCheckpoint {
+		return CheckpointNameError(CurrentCheckpoint)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e, err := s.Environment(en)
+	if err != nil {
+		return err
+	}
+	en = envName(en)
+	s.checkpoints.mu.Lock()
+	defer s.checkpoints.mu.Unlock()
+	cs, ok := s.checkpoints.has[en]
+	if !ok {
+		cs = make(map[string]*checkpoint)
+		s.checkpoints.has[en] = cs
+	}
+	if _, exists := cs[name]; exists {
+		return CheckpointExistsError(name, en)
+	}
+	cs[name] = &checkpoint{name, e, env.State(e), time.Now()}
+	return nil
+}
+
+func (s *Server) checkpoint(en, name string) (*checkpoint, error) {
+	en = envName(en)
+	s.checkpoints.mu.Lock()
+	defer s.checkpoints.mu.Unlock()
+	if c, ok := s.checkpoints.has[en][name]; ok {
+		return c, nil
+	}
+	return nil, NoCheckpointError(name, en)
+}
+
+// Returns the environment of the named checkpoint, or the named environment as
+// it currently is.
+func (s *Server) checkpointEnv(en, name string) (env.Env, error) {
+	if name == CurrentCheckpoint {
+		return s.Environment(en)
+	}
+	c, err := s.checkpoint(en, name)
+	if err != nil {
+		return nil, err
+	}
+	return c.e, nil
+}
+
+// Returns the checkpoints of the named environment, oldest first.
+func (s *Server) Checkpoints(en string) []string {
+	en = envName(en)
+	s.checkpoints.mu.Lock()
+	defer s.checkpoints.mu.Unlock()
+	var cs []*checkpoint
+	for _, c := range s.checkpoints.has[en] {
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].taken.Before(cs[j].taken) })
+	var ret []string
+	for _, c := range cs {
+		ret = append(ret, fmt.Sprintf("%s %s", c.name, c.taken.UTC().Format(time.RFC3339)))
+	}
+	return ret
+}
+
+// Replaces the named environment with the environment of the named checkpoint,
+// its features keeping state as they were when checkpointed, leaving the
+// checkpoint to roll back to again.
+func (s *Server) Rollback(ctx context.Context, en, name string) error {
+	c, err := s.checkpoint(en, name)
+	if err != nil {
+		return err
+	}
+	s.envs.staging.Lock()
+	defer s.envs.staging.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cur, err := s.Environment(en)
+	if err != nil {
+		return err
+	}
+	if err := env.SetState(c.e, c.state); err != nil {
+		return err
+	}
+	return s.replaceEnvironment(en, cur, c.e)
+}
+
+// Returns how the checkpoint named to differs from the checkpoint named from,
+// either being the environment as it currently is when named current.
+func (s *Server) Diff(en, from, to string) (*env.Difference, error) {
+	a, err := s.checkpointEnv(en, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.checkpointEnv(en, to)
+	if err != nil {
+		return nil, err
+	}
+	return env.Diff(a, b), nil
+}
+
+func dropCheckpoints(s *Server, en string) {
+	s.checkpoints.mu.Lock()
+	defer s.checkpoints.mu.Unlock()
+	delete(s.checkpoints.has, en)
+}
+
+func changeStrings(cs []env.Change) []string {
+	var ret []string
+	for _, c := range cs {
+		ret = append(ret, c.String())
+	}
+	return ret
+}
+
+func checkpointRespond(a Action) HandlerFunc {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		d := r.Data
+		if d == nil {
+			d = data.New("")
+		}
+		en := d.ToString("meta.env")
+		var err error
+		switch {
+		case actionIs(a, CHECKPOINT):
+			err = s.Checkpoint(ctx, en, d.ToString("checkpoint_name"))
+		case actionIs(a, ROLLBACK):
+			err = s.Rollback(ctx, en, d.ToString("checkpoint_name"))
+		case actionIs(a, DIFF):
+			var df *env.Difference
+			df, err = s.Diff(en, d.ToString("diff_from"), d.ToString("diff_to"))
+			if err == nil {
+				d.Set(
+					data.NewStringsItem("diff.added", changeStrings(df.Added)...),
+					data.NewStringsItem("diff.removed", changeStrings(df.Removed)...),
+					data.NewStringsItem("diff.changed", changeStrings(df.Changed)...),
+				)
+			}
+		}
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
+		resp := EmptyResponse()
+		d.Set(data.NewStringsItem("checkpoints", s.Checkpoints(en)...))
+		resp.Data = d
+		return resp.ToByte()
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"os"
 	"sort"
+	"time"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
 	"github.com/Laughs-In-Flowers/log"
@@ -93,7 +95,7 @@ func configure(e *Server, conf ...Config) error {
 }
 
 func (c *configuration) Configure() error {
-	sort.Sort(c.list)
+	sort.Stable(c.list)
 
 	err := configure(c.s, c.list...)
 	if err == nil {
@@ -110,8 +112,12 @@ func (c *configuration) Configured() bool {
 var builtIns = []Config{
 	config{1000, sLogger},
 	config{1001, sSocketPath},
+	config{1001, sTimeout},
+	config{1001, sShutdownTimeout},
 	config{1002, sListener},
+	config{1003, sSocketAccess},
 	config{1003, sFeatureEnv},
+	config{1003, sAuditLog},
 }
 
 func SetLogger(l log.Logger) Config {
@@ -142,8 +148,87 @@ func sSocketPath(s *Server) error {
 	return nil
 }
 
+// Sets a file the server writes its process id to while serving.
+func SetPidFile(p string) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.PidFile = p
+		return nil
+	})
+}
+
+// Sets a tcp address, e.g. "localhost:9180", the server serves Prometheus
+// format metrics from at /metrics.
+func SetMetricsAddr(addr string) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.MetricsAddr = addr
+		return nil
+	})
+}
+
+// Sets a file the server appends a JSON line to for every mutating request.
+func SetAuditLog(path string) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.AuditLog = path
+		return nil
+	})
+}
+
+func sAuditLog(s *Server) error {
+	if s.AuditLog == "" {
+		return nil
+	}
+	a, err := openAudit(s.AuditLog)
+	if err != nil {
+		return err
+	}
+	s.audit = a
+	return nil
+}
+
+// Sets the server side limit for handling any single request. A negative
+// duration removes the limit.
+func SetTimeout(d time.Duration) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.Timeout = d
+		return nil
+	})
+}
+
+func sTimeout(s *Server) error {
+	if s.Timeout == 0 {
+		s.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+// Sets the limit for draining in-flight requests on shutdown. A negative
+// duration waits on in-flight requests indefinitely.
+func SetShutdownTimeout(d time.Duration) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.ShutdownTimeout = d
+		return nil
+	})
+}
+
+func sShutdownTimeout(s *Server) error {
+	if s.ShutdownTimeout == 0 {
+		s.ShutdownTimeout = defaultShutdownTimeout
+	}
+	return nil
+}
+
+// Creates the listener, with a configured socket mode or group, first with a
+// socket only the server user may connect to, so no connection is queued
+// before sSocketAccess applies them.
 func sListener(s *Server) error {
-	lr := NewListener(s.SocketPath, s.process)
+	if s.SocketMode != 0 || s.SocketGroup != "" {
+		restore, mode := restrictSocket()
+		defer restore()
+		if s.SocketMode == 0 {
+			s.SocketMode = mode
+		}
+	}
+	lr := NewListener(s.SocketPath, s.Timeout, s.process)
 	if lr.Error != nil {
 		return lr.Error
 	}
@@ -151,6 +236,71 @@ func sListener(s *Server) error {
 	return nil
 }
 
+// Sets the file mode of the server socket.
+func SetSocketMode(m os.FileMode) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.SocketMode = m
+		return nil
+	})
+}
+
+// Sets the owning group of the server socket, by group name or id.
+func SetSocketGroup(g string) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.SocketGroup = g
+		return nil
+	})
+}
+
+func sSocketAccess(s *Server) error {
+	if s.SocketGroup != "" {
+		gid, err := lookupGid(s.SocketGroup)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(s.SocketPath, -1, gid); err != nil {
+			return err
+		}
+	}
+	if s.SocketMode != 0 {
+		return os.Chmod(s.SocketPath, s.SocketMode)
+	}
+	return nil
+}
+
+// Allows requests from peers with these user ids. Setting any allowed user or
+// group id denies requests from all other peers, except those sharing the
+// server user id.
+func SetAllowUid(uids ...int) Config {
+	return DefaultConfig(func(s *Server) error {
+		for _, u := range uids {
+			s.access.uids[u] = true
+		}
+		return nil
+	})
+}
+
+// Allows requests from peers with these group ids, see SetAllowUid.
+func SetAllowGid(gids ...int) Config {
+	return DefaultConfig(func(s *Server) error {
+		for _, g := range gids {
+			s.access.gids[g] = true
+		}
+		return nil
+	})
+}
+
+// Requires the token on all requests to the provided services, e.g. "data"
+// and "system", leaving other services open.
+func SetServiceToken(token string, services ...string) Config {
+	return DefaultConfig(func(s *Server) error {
+		for _, sv := range services {
+			s.access.tokens[sv] = token
+		}
+		return nil
+	})
+}
+
 func SetFeatureEnvironment(f env.Env) Config {
 	return DefaultConfig(func(s *Server) error {
 		s.Env = f
@@ -166,20 +316,20 @@ func sFeatureEnv(s *Server) error {
 }
 
 func SetConstructorPluginDirs(dirs ...string) Config {
-	return NewConfig(50, func(s *Server) error {
+	return NewConfig(1004, func(s *Server) error {
 		for _, d := range dirs {
 			s.Printf("loading plugins from %s", d)
 		}
-		return s.PopulateConstructorPlugin(dirs...)
+		return s.PopulateConstructorPlugin(context.Background(), dirs...)
 	})
 }
 
 func SetFeaturePluginDirs(groups []string, dirs ...string) Config {
-	return NewConfig(1000, func(s *Server) error {
+	return NewConfig(1005, func(s *Server) error {
 		for _, d := range dirs {
 			s.Printf("loading plugins from %s", d)
 		}
-		return s.PopulateFeaturePlugin(groups, dirs...)
+		return s.PopulateFeaturePlugin(context.Background(), groups, dirs...)
 	})
 }
 
@@ -188,7 +338,7 @@ func SetPopulateFeatures(groups []string, files ...string) Config {
 		for _, f := range files {
 			s.Printf("loading features from %s", f)
 		}
-		return s.PopulateFeatureYaml(groups, files...)
+		return s.PopulateFeatureYaml(context.Background(), groups, files...)
 	})
 }
 
@@ -197,7 +347,7 @@ func SetPopulateComponents(groups []string, files ...string) Config {
 		for _, f := range files {
 			s.Printf("loading features from %s", f)
 		}
-		return s.PopulateComponentYaml(groups, files...)
+		return s.PopulateComponentYaml(context.Background(), groups, files...)
 	})
 }
 
@@ -206,7 +356,68 @@ func SetPopulateEntities(groups []string, files ...string) Config {
 		for _, f := range files {
 			s.Printf("loading features from %s", f)
 		}
-		return s.PopulateEntityYaml(groups, files...)
+		return s.PopulateEntityYaml(context.Background(), groups, files...)
+	})
+}
+
+// Sets the interval watched files are polled for changes.
+func SetWatchInterval(d time.Duration) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.WatchInterval = d
+		return nil
+	})
+}
+
+// The order watched kinds are loaded in, so components and entities are
+// loaded after the features they refer to.
+var watchOrder = map[string]int{
+	"features":   2003,
+	"components": 2004,
+	"entities":   2005,
+}
+
+// Loads the provided features, components or entities files into the default
+// environment, reloading them whenever they change.
+func SetWatch(kind string, groups []string, paths ...string) Config {
+	order, ok := watchOrder[kind]
+	if !ok {
+		order = 2005
+	}
+	return NewConfig(order, func(s *Server) error {
+		for _, p := range paths {
+			s.Printf("watching %s from %s", kind, p)
+		}
+		return s.Watch(context.Background(), kind, "", groups, paths...)
+	})
+}
+
+// Sets the file snapshots are written to and restored from when a request
+// provides none.
+func SetSnapshotFile(path string) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.SnapshotFile = path
+		return nil
+	})
+}
+
+// Writes a snapshot to the snapshot file at this interval while serving, and
+// once more on shutdown.
+func SetSnapshotInterval(d time.Duration) Config {
+	return DefaultConfig(func(s *Server) error {
+		s.SnapshotInterval = d
+		return nil
+	})
+}
+
+// Restores every environment from the snapshot in the provided file before
+// any files are populated, the file becoming the snapshot file if none is set.
+func SetRestore(file string) Config {
+	return NewConfig(1006, func(s *Server) error {
+		s.Printf("restoring from %s", file)
+		if s.SnapshotFile == "" {
+			s.SnapshotFile = file
+		}
+		return s.Restore(context.Background(), file)
 	})
 }
 
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The name of the environment a server is configured with, used by requests
+// not selecting an environment with meta.env.
+const DefaultEnvironment = "default"
+
+var (
+	NoEnvironmentError     = xrr.Xrror("no environment named %s").Out
+	EnvironmentExistsError = xrr.Xrror("an environment named %s already exists").Out
+	EnvironmentNameError   = xrr.Xrror("an environment name is required")
+	DropDefaultError       = xrr.Xrror("the default environment cannot be dropped")
+)
+
+type environments struct {
+	mu      sync.RWMutex
+	has     map[string]env.Env
+	staging sync.Mutex
+}
+
+func newEnvironments() *environments {
+	return &environments{has: make(map[string]env.Env)}
+}
+
+func isDefault(name string) bool {
+	return name == "" || name == DefaultEnvironment
+}
+
+// Returns the named environment, the default environment for an empty name.
+func (s *Server) Environment(name string) (env.Env, error) {
+	s.envs.mu.RLock()
+	defer s.envs.mu.RUnlock()
+	if isDefault(name) {
+		return s.Env, nil
+	}
+	if e, ok := s.envs.has[name]; ok {
+		return e, nil
+	}
+	return nil, NoEnvironmentError(name)
+}
+
+// Returns the sorted names of all environments, including the default.
+func (s *Server) Environments() []string {
+	s.envs.mu.RLock()
+	defer s.envs.mu.RUnlock()
+	ret := []string{DefaultEnvironment}
+	for k := range s.envs.has {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret[1:])
+	return ret
+}
+
+func (s *Server) setEnvironment(name string, e env.Env) error {
+	if name == "" {
+		return EnvironmentNameError
+	}
+	s.envs.mu.Lock()
+	defer s.envs.mu.Unlock()
+	if _, exists := s.envs.has[name]; exists || isDefault(name) {
+		return EnvironmentExistsError(name)
+	}
+	s.envs.has[name] = e
+	return nil
+}
+
+// Replaces the named environment with next, provided it is still prev.
+func (s *Server) replaceEnvironment(name string, prev, next env.Env) error {
+	s.envs.mu.Lock()
+	defer s.envs.mu.Unlock()
+	switch {
+	case isDefault(name):
+		if s.Env != prev {
+			return EnvironmentChangeError(DefaultEnvironment)
+		}
+		s.Env = next
+	default:
+		if e, ok := s.envs.has[name]; !ok || e != prev {
+			return EnvironmentChangeError(name)
+		}
+		s.envs.has[name] = next
+	}
+	return nil
+}
+
+// Applies fn to a copy of the named environment, replacing the environment
+// with the copy only if fn returns no error, so that a change is made
+// completely or not at all. When validating, a change leaving references
+// broken that were not broken before is also not made. Changes are staged one
+// at a time.
+func (s *Server) stage(ctx context.Context, name string, validate bool, fn func(env.Env) error) error {
+	s.envs.staging.Lock()
+	defer s.envs.staging.Unlock()
+	cur, err := s.Environment(name)
+	if err != nil {
+		return err
+	}
+	scratch, err := env.Clone(ctx, cur)
+	if err != nil {
+		return err
+	}
+	if err := fn(scratch); err != nil {
+		return err
+	}
+	if validate {
+		if err := brokenBy(cur, scratch); err != nil {
+			return err
+		}
+	}
+	return s.replaceEnvironment(name, cur, scratch)
+}
+
+// The reference errors of next not already present in prev.
+func brokenBy(prev, next env.Env) error {
+	had := make(map[string]bool)
+	for _, e := range errorStrings(env.Validate(prev)) {
+		had[e] = true
+	}
+	var errs feature.Errors
+	if ve, ok := env.Validate(next).(feature.Errors); ok {
+		for _, e := range ve {
+			if !had[e.Error()] {
+				errs.Add(e)
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// Creates a new, empty environment with the provided name.
+func (s *Server) CreateEnvironment(name string) error {
+	e, err := env.New()
+	if err != nil {
+		return err
+	}
+	return s.setEnvironment(name, e)
+}
+
+// Creates a new environment with the provided name holding a copy of the
+// features, components and entities of an existing environment.
+func (s *Server) CloneEnvironment(ctx context.Context, from, name string) error {
+	fe, err := s.Environment(from)
+	if err != nil {
+		return err
+	}
+	e, err := env.Clone(ctx, fe)
+	if err != nil {
+		return err
+	}
+	return s.setEnvironment(name, e)
+}
+
+// Removes the named environment and its checkpoints. The default environment
+// cannot be dropped.
+func (s *Server) DropEnvironment(name string) error {
+	if isDefault(name) {
+		return DropDefaultError
+	}
+	s.envs.mu.Lock()
+	defer s.envs.mu.Unlock()
+	if _, ok := s.envs.has[name]; !ok {
+		return NoEnvironmentError(name)
+	}
+	delete(s.envs.has, name)
+	dropCheckpoints(s, name)
+	return nil
+}
+
+// Returns the environment a request selects with meta.env.
+func (s *Server) requestEnv(r *Request) (env.Env, error) {
+	var name string
+	if r.Data != nil {
+		name = r.Data.ToString("meta.env")
+	}
+	return s.Environment(name)
+}
+
+func envRespond(a Action) HandlerFunc {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		d := r.Data
+		if d == nil {
+			d = data.New("")
+		}
+		name := d.ToString("env_name")
+		var err error
+		switch {
+		case actionIs(a, ENVCREATE):
+			err = s.CreateEnvironment(name)
+		case actionIs(a, ENVCLONE):
+			err = s.CloneEnvironment(ctx, d.ToString("env_from"), name)
+		case actionIs(a, ENVDROP):
+			err = s.DropEnvironment(name)
+		}
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
+		resp := EmptyResponse()
+		d.Set(data.NewStringsItem("environments", s.Environments()...))
+		resp.Data = d
+		return resp.ToByte()
+	}
+}
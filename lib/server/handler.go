@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
 	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
@@ -20,7 +23,9 @@ func NewHandler(service, action string, fn HandlerFunc) *Handler {
 	}
 }
 
-type HandlerFunc func(*Server, *Request) []byte
+// A function handling a Request, where the context is done when the client
+// goes away or the server side request limit is exceeded.
+type HandlerFunc func(context.Context, *Server, *Request) []byte
 
 type hActions map[string]HandlerFunc
 
@@ -71,38 +76,57 @@ func (h *Handlers) SetHandle(hs ...*Handler) {
 	}
 }
 
-func pingRespond(*Server, *Request) []byte {
+func pingRespond(context.Context, *Server, *Request) []byte {
 	return NullResponse
 }
 
-func quitRespond(s *Server, r *Request) []byte {
+func quitRespond(ctx context.Context, s *Server, r *Request) []byte {
 	s.Quit()
-	return nil
+	return NullResponse
 }
 
 func applyRespond(a Action) HandlerFunc {
-	return func(s *Server, r *Request) []byte {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		e, err := s.requestEnv(r)
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
 		resp := EmptyResponse()
 		d := r.Data
-		resp.Data = applyDataFrom(a, d, s)
+		resp.Data = applyDataFrom(ctx, a, d, e)
+		resp.Error = rErrFmt(ctx.Err())
 		return resp.ToByte()
 	}
 }
 
-func applyDataFrom(a Action, m *data.Vector, e env.Env) *data.Vector {
+// Applies the features, components or entity named by the provided data from
+// the provided Env, as an apply action does, for use without a server.
+func Apply(ctx context.Context, action string, d *data.Vector, e env.Env) *data.Vector {
+	return applyDataFrom(ctx, ByteAction(action), d, e)
+}
+
+// Applies as the provided Action, stopping between components once the
+// provided context is done, which the caller reports.
+func applyDataFrom(ctx context.Context, a Action, m *data.Vector, e env.Env) *data.Vector {
 	n := m.ToFloat64("meta.priority")
 	switch {
 	case actionIs(a, APPLYFEATURE):
 		f := m.ToStrings("meta.feature")
-		e.Apply(f, m)
+		e.ApplyFor(ctx, 1, f, m)
 	case actionIs(a, APPLYCOMPONENT):
 		id := m.ToString("meta.id")
-		cs := m.ToStrings("meta.component")
-		css := e.GetComponent(n, id, cs...)
-		for _, v := range css {
-			m.SetVector(v.ToString("component.id"), v)
+		for _, c := range m.ToStrings("meta.component") {
+			if ctx.Err() != nil {
+				break
+			}
+			for _, v := range e.GetComponent(n, id, c) {
+				m.SetVector(v.ToString("component.id"), v)
+			}
 		}
 	case actionIs(a, APPLYENTITY):
+		if ctx.Err() != nil {
+			break
+		}
 		en := m.ToString("meta.entity")
 		ent := e.GetEntity(n, en)
 		for _, v := range ent {
@@ -113,93 +137,274 @@ func applyDataFrom(a Action, m *data.Vector, e env.Env) *data.Vector {
 	return m
 }
 
-func populateRespond(s *Server, r *Request) []byte {
-	resp := EmptyResponse()
-	d := r.Data
+type populateFunc func(context.Context, env.Env, *data.Vector) error
+
+// Returns the provided context loading under the feature.LoadMode named by
+// load, for whatever a document does not set a mode for itself, and
+// substituting the name=value variables of vars in every document.
+func loadContext(ctx context.Context, d *data.Vector) (context.Context, error) {
+	m, err := feature.ParseLoadMode(d.ToString("load"))
+	if err != nil {
+		return ctx, err
+	}
+	vars, err := feature.ParseVars(d.ToStrings("vars")...)
+	if err != nil {
+		return ctx, err
+	}
+	return feature.WithVars(feature.WithLoadMode(ctx, m), vars), nil
+}
+
+// Populates a copy of the requested environment, replacing the environment
+// only if everything populates without error, otherwise responding with every
+// error.
+func populateWith(has func(*data.Vector) bool, fn populateFunc) HandlerFunc {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		if _, err := s.requestEnv(r); err != nil {
+			return ErrorResponse(err).ToByte()
+		}
+		resp := EmptyResponse()
+		d := r.Data
+		if !has(d) {
+			resp.Error = "nothing to populate"
+			resp.Data = d
+			return resp.ToByte()
+		}
+
+		err := s.stage(ctx, d.ToString("meta.env"), true, func(e env.Env) error {
+			return fn(ctx, e, d)
+		})
+		resp.Error = rErrFmt(err)
+		d.Set(data.NewStringsItem("errors", errorStrings(err)...))
+		resp.Data = d
+		return resp.ToByte()
+	}
+}
+
+var (
+	populateRespond       = populateWith(populates, Populate)
+	populateInlineRespond = populateWith(populatesInline, PopulateInline)
+)
+
+// The kinds of document populate_inline accepts, each under inline.<kind>.
+var inlineKinds = []string{"features", "components", "entities", "patches"}
+
+var InlineIncludeError = xrr.Xrror("%s includes %s, but an inline document has no files to include; send them inline too").Out
+
+// An inline document is not read from a file, so what it includes is not read.
+func inlineIncludes(name, doc string) error {
+	inc, err := feature.ParseIncludes(name, []byte(doc))
+	if err != nil {
+		return err
+	}
+	if len(inc) > 0 {
+		return InlineIncludeError(name, strings.Join(inc, ", "))
+	}
+	return nil
+}
+
+func populatesInline(d *data.Vector) bool {
+	if len(d.ToStrings("shares"))+len(d.ToStrings("bundles")) > 0 {
+		return true
+	}
+	for _, kind := range inlineKinds {
+		if len(d.ToStrings("inline."+kind)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Populates the provided Env from the share strings, bundles and documents of
+// any feature.Format carried in the provided data, as a populate_inline action
+// does. Errors are located within each document by kind and position, e.g.
+// "inline features 1". Every error is returned.
+func PopulateInline(ctx context.Context, e env.Env, d *data.Vector) error {
+	ctx, err := loadContext(ctx, d)
+	if err != nil {
+		return err
+	}
+	var errs feature.Errors
 	groups := d.ToStrings("groups")
-	var noneOf bool = true
-	if cc := d.ToStrings("constructor-plugin"); cc != nil && len(cc) > 0 {
-		resp.Error = rErrFmt(s.PopulateConstructorPlugin(cc...))
+	if ss := d.ToStrings("shares"); len(ss) > 0 {
+		errs.Add(e.PopulateFeatureGroupString(ctx, groups, ss...))
 	}
-	if cf := d.ToStrings("feature-plugin"); cf != nil && len(cf) > 0 {
-		resp.Error = rErrFmt(s.PopulateFeaturePlugin(groups, cf...))
+	if bs := d.ToStrings("bundles"); len(bs) > 0 {
+		errs.Add(e.PopulateBundle(ctx, groups, bs...))
+	}
+	for _, kind := range inlineKinds {
+		for i, doc := range d.ToStrings("inline." + kind) {
+			name := fmt.Sprintf("inline %s %d", kind, i+1)
+			if err := inlineIncludes(name, doc); err != nil {
+				errs.Add(err)
+				continue
+			}
+			switch kind {
+			case "features":
+				errs.Add(e.PopulateFeatureBytes(ctx, groups, name, []byte(doc)))
+			case "components":
+				errs.Add(e.PopulateComponentBytes(ctx, groups, name, []byte(doc)))
+			case "entities":
+				errs.Add(e.PopulateEntityBytes(ctx, groups, name, []byte(doc)))
+			case "patches":
+				errs.Add(e.PopulatePatchBytes(ctx, groups, name, []byte(doc)))
+			}
+		}
 	}
-	if fs := d.ToStrings("features"); fs != nil && len(fs) > 0 {
-		resp.Error = rErrFmt(s.PopulateFeatureYaml(groups, fs...))
-		noneOf = false
+	return errs.Err()
+}
+
+// Responds with a bundle of the features in the group provided with
+// export_group, or of every feature, and what is made of them.
+func exportRespond(ctx context.Context, s *Server, r *Request) []byte {
+	e, err := s.requestEnv(r)
+	if err != nil {
+		return ErrorResponse(err).ToByte()
 	}
-	if cs := d.ToStrings("components"); cs != nil && len(cs) > 0 {
-		resp.Error = rErrFmt(s.PopulateComponentYaml(groups, cs...))
-		noneOf = false
+	d := r.Data
+	if d == nil {
+		d = data.New("")
 	}
-	if es := d.ToStrings("entities"); es != nil && len(es) > 0 {
-		resp.Error = rErrFmt(s.PopulateEntityYaml(groups, es...))
-		noneOf = false
+	b, err := feature.NewBundle(e, d.ToString("export_group"))
+	if err != nil {
+		return ErrorResponse(err).ToByte()
 	}
-	if noneOf {
-		resp.Error = "nothing to populate"
+	v, err := b.Value()
+	if err != nil {
+		return ErrorResponse(err).ToByte()
 	}
+	resp := EmptyResponse()
+	d.Set(data.NewStringItem("bundle", v))
 	resp.Data = d
 	return resp.ToByte()
 }
 
-func depopulateRespond(s *Server, r *Request) []byte {
+func populates(d *data.Vector) bool {
+	for _, k := range []string{"constructor-plugin", "feature-plugin", "features", "components", "entities", "patches"} {
+		if len(d.ToStrings(k)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Populates the provided Env from the plugins and files named by the provided
+// data, as a populate action does, for use without a server. Patches are
+// applied last, to what the other files define. Every error is returned.
+func Populate(ctx context.Context, e env.Env, d *data.Vector) error {
+	ctx, err := loadContext(ctx, d)
+	if err != nil {
+		return err
+	}
+	var errs feature.Errors
+	groups := d.ToStrings("groups")
+	if cc := d.ToStrings("constructor-plugin"); len(cc) > 0 {
+		errs.Add(e.PopulateConstructorPlugin(ctx, cc...))
+	}
+	if cf := d.ToStrings("feature-plugin"); len(cf) > 0 {
+		errs.Add(e.PopulateFeaturePlugin(ctx, groups, cf...))
+	}
+	if fs := d.ToStrings("features"); len(fs) > 0 {
+		errs.Add(e.PopulateFeatureYaml(ctx, groups, fs...))
+	}
+	if cs := d.ToStrings("components"); len(cs) > 0 {
+		errs.Add(e.PopulateComponentYaml(ctx, groups, cs...))
+	}
+	if es := d.ToStrings("entities"); len(es) > 0 {
+		errs.Add(e.PopulateEntityYaml(ctx, groups, es...))
+	}
+	if ps := d.ToStrings("patches"); len(ps) > 0 {
+		errs.Add(e.PopulatePatchYaml(ctx, groups, ps...))
+	}
+	return errs.Err()
+}
+
+// The message of each error a possibly multiple error holds.
+func errorStrings(err error) []string {
+	var ret []string
+	switch et := err.(type) {
+	case nil:
+	case feature.Errors:
+		for _, e := range et {
+			ret = append(ret, e.Error())
+		}
+	default:
+		ret = append(ret, err.Error())
+	}
+	return ret
+}
+
+func depopulateRespond(ctx context.Context, s *Server, r *Request) []byte {
+	if _, err := s.requestEnv(r); err != nil {
+		return ErrorResponse(err).ToByte()
+	}
 	resp := EmptyResponse()
 	d := r.Data
 	groups := d.ToStrings("groups")
-	err := s.Remove(groups...)
-	if err != nil {
-		resp.Error = err.Error()
-	}
+	err := s.stage(ctx, d.ToString("meta.env"), false, func(e env.Env) error {
+		return e.Remove(groups...)
+	})
+	resp.Error = rErrFmt(err)
 	resp.Data = d
 	return resp.ToByte()
 }
 
 func queryRespond(a Action) HandlerFunc {
-	return func(s *Server, r *Request) []byte {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		e, err := s.requestEnv(r)
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
 		resp := EmptyResponse()
 		d := r.Data
-		resp.Data = queryDataFrom(a, s, d)
+		resp.Data = queryDataFrom(a, s, e, d)
 		return resp.ToByte()
 	}
 }
 
-func queryDataFrom(a Action, s *Server, d *data.Vector) *data.Vector {
+func queryDataFrom(a Action, s *Server, e env.Env, d *data.Vector) *data.Vector {
 	switch {
 	case actionIs(a, STATUS):
-		return statusData(s, d)
+		return statusData(s, e, d)
+	case actionIs(a, STATS):
+		return statsData(s, d)
 	case actionIs(a, QUERYFEATURE):
-		return featureData(s, d)
+		return featureData(e, d)
 	case actionIs(a, QUERYCOMPONENT):
-		return componentData(s, d)
+		return componentData(e, d)
 	case actionIs(a, QUERYENTITY):
-		return entityData(s, d)
+		return entityData(e, d)
+	case actionIs(a, QUERYSOURCE):
+		return sourceData(e, d)
 	}
 
 	return d
 }
 
-func statusData(s *Server, d *data.Vector) *data.Vector {
+func statusData(s *Server, e env.Env, d *data.Vector) *data.Vector {
 	d.Set(data.NewStringItem("socket", s.SocketPath))
+	pid, uptime := s.Uptime()
+	d.Set(data.NewIntItem("pid", pid))
+	d.Set(data.NewStringItem("uptime", uptime.Round(time.Second).String()))
 	d.Set(data.NewStringItem("services", servicesString()))
 	d.Set(data.NewStringItem("actions", actionsString()))
+	d.Set(data.NewStringItem("environments", strings.Join(s.Environments(), ",")))
 
-	lc := s.ListConstructors()
+	lc := e.ListConstructors()
 	cs := taggedFromConstructor(lc...)
 	d.Set(data.NewStringItem("constructors", strings.Join(cs, ",")))
 
-	lf := s.List("")
+	lf := e.ListAll()
 	fs := taggedFromRawFeature(lf...)
 	d.Set(data.NewStringItem("features", strings.Join(fs, ",")))
 
-	cm := s.ListComponents()
+	cm := e.ListComponents()
 	var cml []string
 	for _, v := range cm {
 		cml = append(cml, v.Tag())
 	}
 	d.Set(data.NewStringItem("components", strings.Join(cml, ",")))
 
-	el := s.ListEntities()
+	el := e.ListEntities()
 	var etl []string
 	for _, v := range el {
 		etl = append(etl, v.Tag())
@@ -217,6 +422,9 @@ func featureData(e env.Env, d *data.Vector) *data.Vector {
 		fi := data.NewStringItem("apply", f.From())
 		vi := data.NewStringsItem("values", f.Values()...)
 		d.Set(si, fi, vi)
+		if src, ok := feature.SourceOf(f); ok {
+			d.Set(data.NewStringItem("source", src.String()))
+		}
 	}
 	return d
 }
@@ -234,6 +442,9 @@ func componentData(e env.Env, d *data.Vector) *data.Vector {
 		di := data.NewStringsItem("component.defines", c.Defines()...)
 		fi := data.NewStringsItem("component.has_features", c.Features()...)
 		d.Set(di, fi)
+		if src, ok := feature.SourceOf(c); ok {
+			d.Set(data.NewStringItem("component.source", src.String()))
+		}
 	}
 	return d
 }
@@ -247,10 +458,13 @@ func entityData(e env.Env, d *data.Vector) *data.Vector {
 			ee = v
 		}
 	}
-	if e != nil {
+	if ee != nil {
 		di := data.NewStringsItem("entity.defines", ee.Defines()...)
 		ci := data.NewStringsItem("entity.has_components", ee.Components()...)
 		d.Set(di, ci)
+		if src, ok := feature.SourceOf(ee); ok {
+			d.Set(data.NewStringItem("entity.source", src.String()))
+		}
 	}
 	return d
 }
@@ -271,6 +485,16 @@ var localHandlers []*Handler = []*Handler{
 		"status",
 		queryRespond(STATUS),
 	),
+	NewHandler(
+		"query",
+		"stats",
+		queryRespond(STATS),
+	),
+	NewHandler(
+		"query",
+		"audit",
+		auditRespond,
+	),
 	NewHandler(
 		"query",
 		"query_feature",
@@ -286,16 +510,36 @@ var localHandlers []*Handler = []*Handler{
 		"query_entity",
 		queryRespond(QUERYENTITY),
 	),
+	NewHandler(
+		"query",
+		"query_source",
+		queryRespond(QUERYSOURCE),
+	),
+	NewHandler(
+		"query",
+		"export",
+		exportRespond,
+	),
 	NewHandler(
 		"data",
 		"populate_from_files",
 		populateRespond,
 	),
+	NewHandler(
+		"data",
+		"populate_inline",
+		populateInlineRespond,
+	),
 	NewHandler(
 		"data",
 		"depopulate",
 		depopulateRespond,
 	),
+	NewHandler(
+		"data",
+		"watch",
+		watchRespond,
+	),
 	NewHandler(
 		"data",
 		"apply_feature",
@@ -311,6 +555,56 @@ var localHandlers []*Handler = []*Handler{
 		"apply_entity",
 		applyRespond(APPLYENTITY),
 	),
+	NewHandler(
+		"system",
+		"env_create",
+		envRespond(ENVCREATE),
+	),
+	NewHandler(
+		"system",
+		"env_list",
+		envRespond(ENVLIST),
+	),
+	NewHandler(
+		"system",
+		"env_clone",
+		envRespond(ENVCLONE),
+	),
+	NewHandler(
+		"system",
+		"env_drop",
+		envRespond(ENVDROP),
+	),
+	NewHandler(
+		"system",
+		"snapshot",
+		snapshotRespond(SNAPSHOT),
+	),
+	NewHandler(
+		"system",
+		"restore",
+		snapshotRespond(RESTORE),
+	),
+	NewHandler(
+		"system",
+		"checkpoint",
+		checkpointRespond(CHECKPOINT),
+	),
+	NewHandler(
+		"system",
+		"rollback",
+		checkpointRespond(ROLLBACK),
+	),
+	NewHandler(
+		"system",
+		"list_checkpoints",
+		checkpointRespond(LISTCHECKPOINTS),
+	),
+	NewHandler(
+		"system",
+		"diff",
+		checkpointRespond(DIFF),
+	),
 }
 
 var (
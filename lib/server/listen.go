@@ -2,57 +2,206 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"net"
 	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Laughs-In-Flowers/xrr"
 )
 
 type Listener struct {
 	Error error
 	*net.UnixListener
-	process ProcessFunc
-	stopCh  chan struct{}
+	socket   os.FileInfo
+	process  ProcessFunc
+	timeout  time.Duration
+	base     context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	stopped  bool
+	inFlight sync.WaitGroup
 }
 
-func NewListener(socket string, fn ProcessFunc) *Listener {
-	os.Remove(socket)
+var SocketInUseError = xrr.Xrror("a server is already listening at %s").Out
+
+// Removes a stale socket left by a server that did not shut down, returning
+// an error if a server is still listening at the socket.
+func clearSocket(socket string) error {
+	if _, err := os.Stat(socket); os.IsNotExist(err) {
+		return nil
+	}
+	if c, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+		c.Close()
+		return SocketInUseError(socket)
+	}
+	return os.Remove(socket)
+}
+
+func NewListener(socket string, timeout time.Duration, fn ProcessFunc) *Listener {
+	if err := clearSocket(socket); err != nil {
+		return &Listener{Error: err}
+	}
 	l, err := net.ListenUnix("unix", &net.UnixAddr{socket, "unix"})
-	st := make(chan struct{}, 0)
+	if err != nil {
+		return &Listener{Error: err}
+	}
+	l.SetUnlinkOnClose(false)
+	fi, err := os.Stat(socket)
+	base, cancel := context.WithCancel(context.Background())
 	return &Listener{
-		err, l, fn, st,
+		Error:        err,
+		UnixListener: l,
+		socket:       fi,
+		process:      fn,
+		timeout:      timeout,
+		base:         base,
+		cancel:       cancel,
 	}
 }
 
-type ProcessFunc func([]byte) []byte
+// Removes the socket file only if it is the one this Listener created, never
+// a socket a newer server has since created at the same path.
+func (l *Listener) removeSocket(path string) {
+	if fi, err := os.Stat(path); err == nil && os.SameFile(fi, l.socket) {
+		os.Remove(path)
+	}
+}
+
+type ProcessFunc func(context.Context, []byte) []byte
+
+// Provides a context for a single request, done when the timeout is exceeded
+// or the client closes the connection before a response is written.
+func requestContext(base context.Context, c *net.UnixConn, timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	switch {
+	case timeout > 0:
+		ctx, cancel = context.WithTimeout(base, timeout)
+	default:
+		ctx, cancel = context.WithCancel(base)
+	}
+	go func() {
+		var b [1]byte
+		c.Read(b[:])
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// The largest request, prefixed with its length, the server reads.
+const MaxRequestSize = 16 << 20
+
+var (
+	RequestSizeError = xrr.Xrror("request of %d bytes exceeds the maximum of %d").Out
+	framePrefix      = regexp.MustCompile(`^(\d+):`)
+)
 
-func heard(c *net.UnixConn, l func([]byte) []byte) {
+// Reads a request, either in a single read of at most 1024 bytes or, prefixed
+// with its length, in as many reads as its length requires.
+func readRequest(c net.Conn) ([]byte, error) {
 	var buf [1024]byte
 	n, err := c.Read(buf[:])
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	m := framePrefix.FindSubmatch(buf[:n])
+	if m == nil {
+		return buf[:n], nil
+	}
+	size, _ := strconv.Atoi(string(m[1]))
+	if size > MaxRequestSize {
+		return nil, RequestSizeError(size, MaxRequestSize)
+	}
+	ret := make([]byte, size)
+	read := copy(ret, buf[len(m[0]):n])
+	if _, err := io.ReadFull(c, ret[read:]); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func heard(base context.Context, c *net.UnixConn, timeout time.Duration, l ProcessFunc) {
+	defer c.Close()
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+	}
+	in, err := readRequest(c)
+	if err != nil {
+		c.Write(ErrorResponse(err).ToByte())
+		return
 	}
-	req := bytes.Trim(buf[:n], " ")
-	resp := l(req)
+	c.SetReadDeadline(time.Time{})
+	ctx, cancel := requestContext(base, c, timeout)
+	defer cancel()
+	if p, err := peerCredentials(c); err == nil {
+		ctx = withPeer(ctx, p)
+	}
+	req := bytes.Trim(in, " ")
+	resp := l(ctx, req)
 	c.Write(resp)
-	c.Close()
 }
 
-func (l *Listener) start() {
-LISTEN:
+func (l *Listener) isStopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// Accepts connections until stopped, returning nil when stopped or the first
+// non temporary accept error.
+func (l *Listener) start() error {
 	for {
-		select {
-		case <-l.stopCh:
-			break LISTEN
-		default:
-			conn, err := l.AcceptUnix()
-			if err != nil {
-				panic(err)
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if l.isStopped() {
+				return nil
+			}
+			if nErr, ok := err.(net.Error); ok && nErr.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
 			}
-			go heard(conn, l.process)
+			return err
 		}
+		l.mu.Lock()
+		if l.stopped {
+			l.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		l.inFlight.Add(1)
+		l.mu.Unlock()
+		go func() {
+			defer l.inFlight.Done()
+			heard(l.base, conn, l.timeout, l.process)
+		}()
 	}
 }
 
-func (l *Listener) stop() {
+// Stops accepting connections and waits for in-flight requests to finish,
+// cancelling any remaining when the provided context is done.
+func (l *Listener) stop(ctx context.Context) error {
+	l.mu.Lock()
+	l.stopped = true
+	l.mu.Unlock()
 	l.Close()
-	l.stopCh <- struct{}{}
+
+	drained := make(chan struct{})
+	go func() {
+		l.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		l.cancel()
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/data"
+)
+
+type requestMetric struct {
+	count, errors uint64
+	total, max    time.Duration
+}
+
+// A record of one populate or depopulate request.
+type populateEvent struct {
+	at     time.Time
+	action string
+	groups []string
+	err    string
+}
+
+// The number of populate and depopulate events kept in history.
+var historyLength = 100
+
+type metrics struct {
+	mu       sync.Mutex
+	requests map[string]*requestMetric
+	emits    map[string]map[string]uint64
+	history  []populateEvent
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[string]*requestMetric),
+		emits: map[string]map[string]uint64{
+			"feature":   make(map[string]uint64),
+			"component": make(map[string]uint64),
+			"entity":    make(map[string]uint64),
+		},
+	}
+}
+
+var success = []byte(`{"Error":""`)
+
+// Returns the error of a marshaled Response, decoding only if the response is
+// not an immediately recognizable success.
+func responseError(resp []byte) string {
+	if resp == nil || bytes.HasPrefix(resp, success) {
+		return ""
+	}
+	return NewResponse(resp).Error
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// The kind of what an apply request applied, and the tags requested that the
+// provided Env has, so tags that do not exist are never counted.
+func appliedTags(r *Request, e env.Env) (string, []string) {
+	if r.Data == nil || e == nil {
+		return "", nil
+	}
+	var kind string
+	var requested []string
+	var exists func(string) bool
+	switch {
+	case actionIs(r.Action, APPLYFEATURE):
+		kind = "feature"
+		for _, f := range r.Data.ToStrings("meta.feature") {
+			requested = append(requested, strings.ToUpper(f))
+		}
+		exists = func(t string) bool { return e.GetFeature(t) != nil }
+	case actionIs(r.Action, APPLYCOMPONENT):
+		kind, requested = "component", r.Data.ToStrings("meta.component")
+		var have []string
+		for _, c := range e.ListComponents() {
+			have = append(have, c.Tag())
+		}
+		exists = func(t string) bool { return hasTag(have, t) }
+	case actionIs(r.Action, APPLYENTITY):
+		kind, requested = "entity", []string{r.Data.ToString("meta.entity")}
+		var have []string
+		for _, en := range e.ListEntities() {
+			have = append(have, en.Tag())
+		}
+		exists = func(t string) bool { return hasTag(have, t) }
+	default:
+		return "", nil
+	}
+	var ret []string
+	for _, t := range requested {
+		if exists(t) {
+			ret = append(ret, t)
+		}
+	}
+	return kind, ret
+}
+
+// Records a request, counting what it applied from the provided Env, if any.
+func (m *metrics) observe(r *Request, took time.Duration, resp []byte, e env.Env) {
+	service, action := r.Service.String(), r.Action.String()
+	// arbitrary client input should not grow the metrics without bound
+	if !isService(r.Service) {
+		service = string(UNKNOWN)
+	}
+	if !isAction(r.Action) {
+		action = string(UNKNOWN)
+	}
+	rErr := responseError(resp)
+	var kind string
+	var applied []string
+	if rErr == "" {
+		kind, applied = appliedTags(r, e)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := service + "." + action
+	rm, ok := m.requests[key]
+	if !ok {
+		rm = &requestMetric{}
+		m.requests[key] = rm
+	}
+	rm.count++
+	rm.total += took
+	if took > rm.max {
+		rm.max = took
+	}
+	if rErr != "" {
+		rm.errors++
+	}
+
+	switch {
+	case actionIs(r.Action, POPULATEFROMFILES), actionIs(r.Action, POPULATEINLINE), actionIs(r.Action, DEPOPULATE), actionIs(r.Action, RESTORE), actionIs(r.Action, ROLLBACK):
+		var groups []string
+		if r.Data != nil {
+			groups = r.Data.ToStrings("groups")
+		}
+		m.history = append(m.history, populateEvent{time.Now(), action, groups, rErr})
+		if l := len(m.history); l > historyLength {
+			m.history = m.history[l-historyLength:]
+		}
+	}
+
+	for _, t := range applied {
+		m.emits[kind][t]++
+	}
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (m *metrics) requestKeys() []string {
+	var ret []string
+	for k := range m.requests {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+type registrySize struct {
+	env, name string
+	size      int
+}
+
+func registrySizes(s *Server) []registrySize {
+	var ret []registrySize
+	for _, n := range s.Environments() {
+		e, err := s.Environment(n)
+		if err != nil {
+			continue
+		}
+		ret = append(ret,
+			registrySize{n, "constructors", len(e.ListConstructors())},
+			registrySize{n, "features", len(e.ListAll())},
+			registrySize{n, "components", len(e.ListComponents())},
+			registrySize{n, "entities", len(e.ListEntities())},
+		)
+	}
+	return ret
+}
+
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func statsData(s *Server, d *data.Vector) *data.Vector {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.requestKeys() {
+		rm := m.requests[k]
+		pre := "stats.requests." + k
+		d.Set(
+			data.NewIntItem(pre+".count", int(rm.count)),
+			data.NewIntItem(pre+".errors", int(rm.errors)),
+			data.NewStringItem(pre+".latency_avg", (rm.total/time.Duration(rm.count)).String()),
+			data.NewStringItem(pre+".latency_max", rm.max.String()),
+		)
+	}
+
+	for kind, counts := range m.emits {
+		for _, tag := range sortedKeys(counts) {
+			d.Set(data.NewIntItem(fmt.Sprintf("stats.emits.%s.%s", kind, tag), int(counts[tag])))
+		}
+	}
+
+	var history []string
+	for _, h := range m.history {
+		outcome := "ok"
+		if h.err != "" {
+			outcome = h.err
+		}
+		history = append(history, fmt.Sprintf("%s %s [%s] %s",
+			h.at.Format(time.RFC3339), h.action, strings.Join(h.groups, ","), outcome))
+	}
+	d.Set(data.NewStringsItem("stats.history", history...))
+
+	for _, r := range registrySizes(s) {
+		d.Set(data.NewIntItem(fmt.Sprintf("stats.registry.%s.%s", r.env, r.name), r.size))
+	}
+	d.Set(data.NewIntItem("stats.memory.heap_alloc_bytes", int(heapAlloc())))
+
+	return d
+}
+
+func splitKey(k string) (string, string) {
+	spl := strings.SplitN(k, ".", 2)
+	if len(spl) < 2 {
+		return spl[0], ""
+	}
+	return spl[0], spl[1]
+}
+
+func promLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Writes the server metrics in the Prometheus text exposition format.
+func (s *Server) WriteMetrics(w io.Writer) {
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := m.requestKeys()
+
+	fmt.Fprintln(w, "# HELP countfloyd_requests_total Requests handled by service and action.")
+	fmt.Fprintln(w, "# TYPE countfloyd_requests_total counter")
+	for _, k := range keys {
+		sv, ac := splitKey(k)
+		fmt.Fprintf(w, "countfloyd_requests_total{service=%q,action=%q} %d\n", promLabel(sv), promLabel(ac), m.requests[k].count)
+	}
+
+	fmt.Fprintln(w, "# HELP countfloyd_request_errors_total Requests returning an error by service and action.")
+	fmt.Fprintln(w, "# TYPE countfloyd_request_errors_total counter")
+	for _, k := range keys {
+		sv, ac := splitKey(k)
+		fmt.Fprintf(w, "countfloyd_request_errors_total{service=%q,action=%q} %d\n", promLabel(sv), promLabel(ac), m.requests[k].errors)
+	}
+
+	fmt.Fprintln(w, "# HELP countfloyd_request_duration_seconds Time spent handling requests by service and action.")
+	fmt.Fprintln(w, "# TYPE countfloyd_request_duration_seconds summary")
+	for _, k := range keys {
+		sv, ac := splitKey(k)
+		rm := m.requests[k]
+		fmt.Fprintf(w, "countfloyd_request_duration_seconds_sum{service=%q,action=%q} %g\n", promLabel(sv), promLabel(ac), rm.total.Seconds())
+		fmt.Fprintf(w, "countfloyd_request_duration_seconds_count{service=%q,action=%q} %d\n", promLabel(sv), promLabel(ac), rm.count)
+	}
+
+	fmt.Fprintln(w, "# HELP countfloyd_emits_total Applications by kind and tag.")
+	fmt.Fprintln(w, "# TYPE countfloyd_emits_total counter")
+	for _, kind := range []string{"feature", "component", "entity"} {
+		counts := m.emits[kind]
+		for _, tag := range sortedKeys(counts) {
+			fmt.Fprintf(w, "countfloyd_emits_total{kind=%q,tag=%q} %d\n", kind, promLabel(tag), counts[tag])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP countfloyd_registry_size Registered items by registry.")
+	fmt.Fprintln(w, "# TYPE countfloyd_registry_size gauge")
+	for _, r := range registrySizes(s) {
+		fmt.Fprintf(w, "countfloyd_registry_size{env=%q,registry=%q} %d\n", promLabel(r.env), r.name, r.size)
+	}
+
+	fmt.Fprintln(w, "# HELP countfloyd_heap_alloc_bytes Bytes of allocated heap objects.")
+	fmt.Fprintln(w, "# TYPE countfloyd_heap_alloc_bytes gauge")
+	fmt.Fprintf(w, "countfloyd_heap_alloc_bytes %d\n", heapAlloc())
+}
+
+// Serves Prometheus format metrics at /metrics on the configured address until
+// the provided context is done.
+func (s *Server) serveMetrics(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		s.WriteMetrics(w)
+	})
+	hs := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		hs.Close()
+	}()
+	go hs.Serve(l)
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// Returns the credentials of the process at the other end of the connection
+// through SO_PEERCRED.
+func peerCredentials(c *net.UnixConn) (*Peer, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var cErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cErr != nil {
+		return nil, cErr
+	}
+	return &Peer{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}, nil
+}
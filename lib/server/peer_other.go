@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import "net"
+
+// Peer credentials are only available on linux.
+func peerCredentials(c *net.UnixConn) (*Peer, error) {
+	return nil, PeerUnsupportedError
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"strconv"
 	"strings"
 
 	"github.com/Laughs-In-Flowers/data"
@@ -12,11 +13,14 @@ type Request struct {
 	Service Service
 	Action  Action
 	Data    *data.Vector
+	Token   string
 }
 
 func NewRequest(s Service, a Action, d *data.Vector) *Request {
 	return &Request{
-		s, a, d,
+		Service: s,
+		Action:  a,
+		Data:    d,
 	}
 }
 
@@ -28,7 +32,7 @@ func request(req []byte) *Request {
 
 func parse(s Space) *Request {
 	return &Request{
-		s.Service(), s.Action(), s.Data(),
+		s.Service(), s.Action(), s.Data(), s.Token(),
 	}
 }
 
@@ -43,21 +47,36 @@ func (r *Request) ToByte() []byte {
 		l = append(l, []byte(err.Error()))
 	}
 	l = append(l, b)
+	if r.Token != "" {
+		l = append(l, []byte(r.Token))
+	}
 	return bytes.Join(l, Sep)
 }
 
+// Returns the request prefixed with its length, as the client sends requests
+// so that the server reads all of a request larger than a single read.
+func (r *Request) Frame() []byte {
+	b := r.ToByte()
+	return append([]byte(strconv.Itoa(len(b))+":"), b...)
+}
+
 type Space [][]byte
 
+// Splits a request into service, action, data and optional token. As data, e.g.
+// an inline document, may itself hold the separator, the last field is only a
+// token if what precedes it is the whole of the data.
 func NewSpace(in []byte) Space {
 	ret := make(Space, 0)
 	fs := bytes.Split(in, Sep)
-	if len(fs) == 3 {
-		for _, v := range fs {
-			ret = append(ret, v)
-		}
+	switch {
+	case len(fs) < 3:
 		return ret
+	case len(fs) > 3:
+		if d := bytes.Join(fs[2:len(fs)-1], Sep); json.Valid(d) {
+			return append(ret, fs[0], fs[1], d, fs[len(fs)-1])
+		}
 	}
-	return ret
+	return append(ret, fs[0], fs[1], bytes.Join(fs[2:], Sep))
 }
 
 func (s Space) Service() Service {
@@ -68,6 +87,14 @@ func (s Space) Action() Action {
 	return s[1]
 }
 
+// Returns the optional access token of the request, or an empty string.
+func (s Space) Token() string {
+	if len(s) > 3 {
+		return string(s[3])
+	}
+	return ""
+}
+
 func (s Space) Data() *data.Vector {
 	d := data.New("")
 	err := json.Unmarshal(s[2], &d)
@@ -154,27 +181,59 @@ var (
 	PING              = []byte("ping")
 	QUIT              = []byte("quit")
 	STATUS            = []byte("status")
+	STATS             = []byte("stats")
+	AUDIT             = []byte("audit")
 	QUERYFEATURE      = []byte("query_feature")
 	QUERYCOMPONENT    = []byte("query_component")
 	QUERYENTITY       = []byte("query_entity")
+	QUERYSOURCE       = []byte("query_source")
+	EXPORT            = []byte("export")
 	POPULATEFROMFILES = []byte("populate_from_files")
+	POPULATEINLINE    = []byte("populate_inline")
 	DEPOPULATE        = []byte("depopulate")
+	WATCH             = []byte("watch")
 	APPLYFEATURE      = []byte("apply_feature")
 	APPLYCOMPONENT    = []byte("apply_component")
 	APPLYENTITY       = []byte("apply_entity")
+	ENVCREATE         = []byte("env_create")
+	ENVLIST           = []byte("env_list")
+	ENVCLONE          = []byte("env_clone")
+	ENVDROP           = []byte("env_drop")
+	SNAPSHOT          = []byte("snapshot")
+	RESTORE           = []byte("restore")
+	CHECKPOINT        = []byte("checkpoint")
+	ROLLBACK          = []byte("rollback")
+	LISTCHECKPOINTS   = []byte("list_checkpoints")
+	DIFF              = []byte("diff")
 
 	actions []Action = []Action{
 		PING,
 		QUIT,
 		STATUS,
+		STATS,
+		AUDIT,
 		QUERYFEATURE,
 		QUERYCOMPONENT,
 		QUERYENTITY,
+		QUERYSOURCE,
+		EXPORT,
 		POPULATEFROMFILES,
+		POPULATEINLINE,
 		DEPOPULATE,
+		WATCH,
 		APPLYFEATURE,
 		APPLYCOMPONENT,
 		APPLYENTITY,
+		ENVCREATE,
+		ENVLIST,
+		ENVCLONE,
+		ENVDROP,
+		SNAPSHOT,
+		RESTORE,
+		CHECKPOINT,
+		ROLLBACK,
+		LISTCHECKPOINTS,
+		DIFF,
 	}
 )
 
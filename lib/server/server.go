@@ -1,14 +1,20 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
 	"github.com/Laughs-In-Flowers/log"
+	"github.com/Laughs-In-Flowers/xrr"
 )
 
 type Server struct {
@@ -17,78 +23,196 @@ type Server struct {
 	log.Logger
 	env.Env
 	*Listener
-	interrupt chan os.Signal
+	access      *access
+	envs        *environments
+	checkpoints *checkpoints
+	metrics     *metrics
+	watcher     *watcher
+	audit       *audit
+	lastId      uint64
+	started     time.Time
+	interrupt   chan os.Signal
+	quit        chan struct{}
+	quitOnce    sync.Once
 	*Handlers
 }
 
 func New(c ...Config) *Server {
 	s := &Server{
-		settings:  &settings{},
-		interrupt: make(chan os.Signal, 0),
-		Handlers:  NewHandlers(localHandlers...),
+		settings:    &settings{},
+		interrupt:   make(chan os.Signal, 1),
+		quit:        make(chan struct{}),
+		access:      newAccess(),
+		envs:        newEnvironments(),
+		checkpoints: newCheckpoints(),
+		metrics:     newMetrics(),
+		watcher:     newWatcher(),
+		Handlers:    NewHandlers(localHandlers...),
 	}
 
-	signal.Notify(
-		s.interrupt,
-		//os.Interrupt,
-		//os.Kill,
-		//syscall.SIGINT,
-		//syscall.SIGTERM,
-		//syscall.SIGKILL,
-	)
-
 	s.Configuration = newConfiguration(s, c...)
 
 	return s
 }
 
 type settings struct {
-	SocketPath string
+	SocketPath       string
+	SocketMode       os.FileMode
+	SocketGroup      string
+	PidFile          string
+	MetricsAddr      string
+	AuditLog         string
+	WatchInterval    time.Duration
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+	Timeout          time.Duration
+	ShutdownTimeout  time.Duration
 }
 
 func newSettings() *settings {
-	return &settings{"/tmp/countfloyd_0_0-socket"}
+	return &settings{"/tmp/countfloyd_0_0-socket", 0, "", "", "", "", defaultWatchInterval, "", 0, defaultTimeout, defaultShutdownTimeout}
 }
 
-func (s *Server) Serve() {
+var (
+	// The default server side limit for handling any single request.
+	defaultTimeout = 30 * time.Second
+	// The default limit for draining in-flight requests on shutdown.
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// Serve accepts requests until the server is quit, by request or by SIGINT or
+// SIGTERM, then shuts down and returns.
+func (s *Server) Serve() error {
 	s.Print("serving....")
 
-	go s.start()
+	s.started = time.Now()
+	if err := s.writePid(); err != nil {
+		s.Shutdown()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if s.MetricsAddr != "" {
+		if err := s.serveMetrics(ctx); err != nil {
+			s.Shutdown()
+			return err
+		}
+	}
+
+	go s.watch(ctx)
+	if autoSnapshots(s) {
+		go s.autoSnapshot(ctx)
+	}
+
+	signal.Notify(s.interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(s.interrupt)
+
+	listening := make(chan error, 1)
+	go func() {
+		listening <- s.start()
+	}()
 
 	for {
 		select {
 		case sig := <-s.interrupt:
 			s.SignalHandler(sig)
+		case <-s.quit:
+			return s.Shutdown()
+		case err := <-listening:
+			s.Printf("listener error: %s", err)
+			s.Shutdown()
+			return err
 		}
 	}
 }
 
-//var NoItemError = xrr.Xrr("No %s with the tag %s is available.").Out
-
-func (s *Server) process(r []byte) []byte {
-	req := request(r)
+var PanicError = xrr.Xrror("recovered from panic handling request: %v").Out
+
+func (s *Server) process(ctx context.Context, r []byte) (resp []byte) {
+	var req *Request
+	var a *auditing
+	id, begin := s.nextRequestId(), time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			err := PanicError(p)
+			s.Print(err.Error())
+			resp = ErrorResponse(err).ToByte()
+		}
+		if req != nil {
+			e, _ := s.requestEnv(req)
+			s.metrics.observe(req, time.Since(begin), resp, e)
+		}
+		s.finishAudit(a, resp)
+	}()
+	req = request(r)
+	a = s.beginAudit(ctx, id, req)
+	if err := s.authorize(ctx, req); err != nil {
+		return ErrorResponse(err).ToByte()
+	}
 	fn, err := s.GetRequestedHandle(req)
 	if fn != nil {
-		return fn(s, req)
+		return fn(ctx, s, req)
 	}
 	return ErrorResponse(err).ToByte()
 }
 
-func (s *Server) Close() {
-	s.stop()
+func (s *Server) writePid() error {
+	if s.PidFile == "" {
+		return nil
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()) + "\n")
+	return ioutil.WriteFile(s.PidFile, pid, 0644)
 }
 
-func (s *Server) Quit() {
+// The process id and time serving of this Server.
+func (s *Server) Uptime() (int, time.Duration) {
+	if s.started.IsZero() {
+		return os.Getpid(), 0
+	}
+	return os.Getpid(), time.Since(s.started)
+}
+
+// Stops accepting requests, waiting on in-flight requests no longer than the
+// shutdown timeout, writes a last snapshot if snapshotting periodically, and
+// removes the socket and any pid file.
+func (s *Server) Shutdown() error {
 	s.Print("exiting")
-	s.Close()
-	os.Remove(s.SocketPath)
-	os.Exit(0)
+	ctx := context.Background()
+	if s.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ShutdownTimeout)
+		defer cancel()
+	}
+	err := s.stop(ctx)
+	if autoSnapshots(s) {
+		if serr := s.WriteSnapshot(s.SnapshotFile); serr != nil {
+			s.Printf("snapshot error: %s", serr)
+		}
+	}
+	if s.PidFile != "" {
+		os.Remove(s.PidFile)
+	}
+	s.removeSocket(s.SocketPath)
+	if s.audit != nil {
+		s.audit.close()
+	}
+	return err
+}
+
+// Quit signals a serving Server to shut down. It does not block and is safe
+// to call from a handler.
+func (s *Server) Quit() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
 }
 
 func (s *Server) SignalHandler(sig os.Signal) {
 	msg := fmt.Sprintf("received signal %v", sig)
 	switch sig {
-	case os.Interrupt, os.Kill, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL:
+	case os.Interrupt, syscall.SIGINT, syscall.SIGTERM:
 		s.Print(msg)
 		s.Quit()
 	default:
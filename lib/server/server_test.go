@@ -2,9 +2,24 @@ package server
 
 import (
 	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
 	"github.com/Laughs-In-Flowers/data"
+
+	_ "github.com/Laughs-In-Flowers/countfloyd/lib/feature/constructors_common"
 )
 
 var (
@@ -48,6 +63,17 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestSpace(t *testing.T) {
+	d := data.New("")
+	d.Set(data.NewStringItem("inline", "- tag: c++\n  values: [a++b]\n"))
+	r := NewRequest(DATA, POPULATEINLINE, d)
+	r.Token = "secret"
+	nr := request(r.ToByte())
+	if nr.Token != "secret" || nr.Data.ToString("inline") != d.ToString("inline") {
+		t.Errorf("a request with the separator in its data was not parsed: %q %q", nr.Token, nr.Data.ToString("inline"))
+	}
+}
+
 func TestResponse(t *testing.T) {
 	for _, r := range testResponses {
 		rb := r.ToByte()
@@ -58,4 +84,634 @@ func TestResponse(t *testing.T) {
 	}
 }
 
-func TestServer(t *testing.T) {}
+func send(t *testing.T, socket string, r *Request) *Response {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(r.ToByte()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewResponse(b)
+}
+
+func panicRespond(context.Context, *Server, *Request) []byte {
+	panic("test panic")
+}
+
+func TestServer(t *testing.T) {
+	socket := filepath.Join(os.TempDir(), "countfloyd_test_socket")
+	auditLog := filepath.Join(os.TempDir(), "countfloyd_test_audit")
+	os.Remove(auditLog)
+	defer os.Remove(auditLog)
+	s := New(
+		SetSocketPath(socket),
+		SetHandler(NewHandler("system", "panic", panicRespond)),
+		SetServiceToken("secret", "data"),
+		SetAuditLog(auditLog),
+	)
+	if err := s.Configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l := NewListener(socket, 0, nil); l.Error == nil {
+		t.Error("listening on a socket in use by a server did not return an error")
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+
+	resp := send(t, socket, NewRequest(SYSTEM, ByteAction("panic"), nil))
+	if resp.Error == "" {
+		t.Error("a panicking handler did not return an error response")
+	}
+
+	resp = send(t, socket, NewRequest(SYSTEM, ByteAction("ping"), nil))
+	if resp.Error != "" {
+		t.Errorf("ping after a panicking handler returned an error: %s", resp.Error)
+	}
+
+	resp = send(t, socket, NewRequest(DATA, ByteAction("depopulate"), nil))
+	if resp.Error == "" {
+		t.Error("a data request without the required token did not return an error")
+	}
+
+	tr := NewRequest(DATA, ByteAction("depopulate"), container("TOKEN"))
+	tr.Token = "secret"
+	resp = send(t, socket, tr)
+	if resp.Error != "" {
+		t.Errorf("a data request with the required token returned an error: %s", resp.Error)
+	}
+
+	ad := container("APPLY")
+	ad.Set(data.NewStringsItem("meta.feature", "missing"))
+	ar := NewRequest(DATA, APPLYFEATURE, ad)
+	ar.Token = "secret"
+	send(t, socket, ar)
+
+	resp = send(t, socket, NewRequest(QUERY, ByteAction("stats"), container("STATS")))
+	if n := resp.Data.ToInt("stats.emits.feature.MISSING"); n != 0 {
+		t.Errorf("stats counted %d applications of a feature that does not exist", n)
+	}
+	if n := resp.Data.ToInt("stats.requests.system.ping.count"); n != 1 {
+		t.Errorf("stats counted %d ping requests, expected 1", n)
+	}
+	if n := resp.Data.ToInt("stats.requests.system.panic.errors"); n != 1 {
+		t.Errorf("stats counted %d panic request errors, expected 1", n)
+	}
+	if h := resp.Data.ToStrings("stats.history"); len(h) != 2 {
+		t.Errorf("stats history has %d depopulate events, expected 2: %v", len(h), h)
+	}
+
+	ed := container("ENV")
+	ed.Set(data.NewStringItem("env_name", "alt"))
+	resp = send(t, socket, NewRequest(SYSTEM, ByteAction("env_create"), ed))
+	if envs := resp.Data.ToStrings("environments"); resp.Error != "" || len(envs) != 2 {
+		t.Errorf("creating an environment failed: %s %v", resp.Error, envs)
+	}
+
+	sd := container("STATUS")
+	sd.Set(data.NewStringItem("meta.env", "alt"))
+	resp = send(t, socket, NewRequest(QUERY, ByteAction("status"), sd))
+	if resp.Error != "" {
+		t.Errorf("a request selecting a created environment returned an error: %s", resp.Error)
+	}
+
+	sd.Set(data.NewStringItem("meta.env", "missing"))
+	resp = send(t, socket, NewRequest(QUERY, ByteAction("status"), sd))
+	if resp.Error == "" {
+		t.Error("a request selecting a missing environment did not return an error")
+	}
+
+	ed.Set(data.NewStringItem("env_name", DefaultEnvironment))
+	resp = send(t, socket, NewRequest(SYSTEM, ByteAction("env_drop"), ed))
+	if resp.Error == "" {
+		t.Error("dropping the default environment did not return an error")
+	}
+
+	resp = send(t, socket, NewRequest(QUERY, ByteAction("audit"), container("AUDIT")))
+	if a := resp.Data.ToStrings("audit"); len(a) != 4 {
+		t.Errorf("audit log has %d entries, expected 4: %v", len(a), a)
+	} else {
+		var e AuditEntry
+		if err := json.Unmarshal([]byte(a[1]), &e); err != nil {
+			t.Error(err)
+		}
+		if e.Action != "depopulate" || e.Outcome != "ok" || e.Uid != os.Getuid() {
+			t.Errorf("unexpected audit entry: %s", a[1])
+		}
+	}
+
+	send(t, socket, NewRequest(SYSTEM, ByteAction("quit"), nil))
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve returned an error on quit: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not return from serving after quit")
+	}
+
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Error("server socket remains after shutdown")
+	}
+}
+
+func valuesConstructor(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
+	values := r.MustGetValues()
+	ef := func() data.Item {
+		return data.NewStringsItem(tag, values...)
+	}
+	mf := func(d *data.Vector) {
+		d.Set(ef())
+	}
+	return feature.NewInformer("TEST_VALUES", r.Group, tag, r.Values, r.Values),
+		feature.NewEmitter(ef),
+		feature.NewMapper(mf)
+}
+
+// Constructs as valuesConstructor, but reports a From other than its tag.
+func otherConstructor(tag string, r *feature.RawFeature, e feature.CEnv) (feature.Informer, feature.Emitter, feature.Mapper) {
+	_, em, m := valuesConstructor(tag, r, e)
+	return feature.NewInformer("OTHER", r.Group, tag, r.Values, r.Values), em, m
+}
+
+// Returns a configured Server of an Env with the test_values and test_other
+// constructors, its socket in a temporary directory, also returned, removed
+// once the test completes.
+func testServer(t *testing.T, name string, cnf ...Config) (*Server, string) {
+	dir, err := ioutil.TempDir("", "countfloyd_"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	e, err := env.New(env.SetConstructors(
+		feature.DefaultConstructor("TEST_VALUES", valuesConstructor),
+		feature.DefaultConstructor("TEST_OTHER", otherConstructor),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(append([]Config{
+		SetSocketPath(filepath.Join(dir, "socket")),
+		SetFeatureEnvironment(e),
+	}, cnf...)...)
+	if err := s.Configure(); err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func TestWatch(t *testing.T) {
+	s, dir := testServer(t, "watch")
+	defer s.Shutdown()
+
+	file := filepath.Join(dir, "features.yaml")
+	modified := time.Now()
+	write := func(y string) {
+		if err := ioutil.WriteFile(file, []byte(y), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified = modified.Add(time.Second)
+		os.Chtimes(file, modified, modified)
+	}
+
+	has := func(tag string) bool {
+		e, _ := s.Environment("")
+		return e.GetFeature(tag) != nil
+	}
+
+	ctx := context.Background()
+
+	write("- tag: one\n  apply: test_values\n  values: [a]\n- tag: two\n  apply: test_values\n  values: [b]\n")
+	if err := s.Watch(ctx, "features", "", nil, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !has("one") || !has("two") {
+		t.Error("features of a watched file were not loaded")
+	}
+
+	write("- tag: [")
+	s.poll(ctx)
+	if !has("one") || !has("two") {
+		t.Error("a watched file failing to parse disturbed previously loaded features")
+	}
+	if errs := watchData(s, data.New("")).ToStrings("watch.errors"); len(errs) != 1 {
+		t.Errorf("expected a single watch error, have %v", errs)
+	}
+
+	write("- tag: three\n  apply: test_values\n  values: [c]\n")
+	s.poll(ctx)
+	if has("one") || has("two") || !has("three") {
+		t.Error("changing a watched file did not replace the features it contributed")
+	}
+
+	e, _ := s.Environment("")
+	if src, ok := feature.SourceOf(e.GetFeature("three")); !ok || src.File != file || src.Line != 1 {
+		t.Errorf("expected feature three to be sourced from %s:1, have %v", file, src)
+	}
+	sd := data.New("")
+	sd.Set(data.NewStringItem("query_source", file))
+	if fs := sourceData(e, sd).ToStrings("source.features"); len(fs) != 1 || fs[0] != "THREE line 1" {
+		t.Errorf("expected the watched file to define THREE at line 1, have %v", fs)
+	}
+}
+
+func TestPopulate(t *testing.T) {
+	s, dir := testServer(t, "populate")
+	defer s.Shutdown()
+
+	good := filepath.Join(dir, "good.yaml")
+	ioutil.WriteFile(good, []byte("- tag: one\n  apply: test_values\n  values: [a]\n"), 0644)
+	bad := filepath.Join(dir, "bad.yaml")
+	ioutil.WriteFile(bad, []byte("- tag: ["), 0644)
+	empty := filepath.Join(dir, "empty.yaml")
+	ioutil.WriteFile(empty, []byte("- tag: none\n  apply: test_values\n"), 0644)
+	missing := filepath.Join(dir, "missing.yaml")
+	duplicate := filepath.Join(dir, "components.yaml")
+	ioutil.WriteFile(duplicate, []byte("- tag: c\n  features:\n  - tag: one\n"), 0644)
+	unknown := filepath.Join(dir, "unknown.yaml")
+	ioutil.WriteFile(unknown, []byte("- tag: d\n  features:\n  - tag: nothing\n"), 0644)
+	different := filepath.Join(dir, "different.yaml")
+	ioutil.WriteFile(different, []byte("- tag: e\n  features:\n  - tag: one\n    apply: test_values\n    values: [z]\n"), 0644)
+
+	populate := func(key string, files ...string) *Response {
+		d := data.New("")
+		d.Set(data.NewStringsItem(key, files...))
+		return NewResponse(populateRespond(context.Background(), s, NewRequest(DATA, POPULATEFROMFILES, d)))
+	}
+	has := func(tag string) bool {
+		e, _ := s.Environment("")
+		return e.GetFeature(tag) != nil
+	}
+
+	resp := populate("features", good, bad, missing)
+	if errs := resp.Data.ToStrings("errors"); len(errs) != 2 {
+		t.Errorf("expected an error for each of two failing files, have %v", errs)
+	}
+	if has("one") {
+		t.Error("a failing populate was not rolled back")
+	}
+	if !strings.HasPrefix(resp.Error, bad+":1: ") {
+		t.Errorf("expected an error located in %s, have %s", bad, resp.Error)
+	}
+
+	resp = populate("features", empty)
+	if expect := empty + ":1:3: constructing NONE"; !strings.HasPrefix(resp.Error, expect) {
+		t.Errorf("expected a construction error beginning %q, have %q", expect, resp.Error)
+	}
+
+	resp = populate("features", good)
+	if resp.Error != "" || !has("one") {
+		t.Errorf("populating a good file failed: %s", resp.Error)
+	}
+
+	resp = populate("components", different)
+	if resp.Error == "" {
+		t.Error("a component defining an existing feature differently did not return an error")
+	}
+	resp = populate("components", unknown)
+	if !strings.Contains(resp.Error, "NOTHING") {
+		t.Errorf("a component naming a missing feature did not return an error naming it: %q", resp.Error)
+	}
+	if e, _ := s.Environment(""); len(e.ListComponents()) != 0 {
+		t.Error("a failing component populate was not rolled back")
+	}
+
+	resp = populate("components", duplicate)
+	if resp.Error != "" {
+		t.Errorf("a component only naming an existing feature failed: %s", resp.Error)
+	}
+	if e, _ := s.Environment(""); len(e.ListComponents()) != 1 {
+		t.Error("a component only naming an existing feature was not populated")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "countfloyd_local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := filepath.Join(dir, "features.yaml")
+	ioutil.WriteFile(fs, []byte("- tag: one\n  apply: test_values\n  values: [a, b]\n"), 0644)
+
+	e, err := env.New(env.SetConstructors(feature.DefaultConstructor("TEST_VALUES", valuesConstructor)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd := data.New("")
+	pd.Set(data.NewStringsItem("features", fs))
+	if err := Populate(context.Background(), e, pd); err != nil {
+		t.Fatal(err)
+	}
+
+	d := data.New("")
+	d.Set(data.NewStringsItem("meta.feature", "one"))
+	if have := Apply(context.Background(), "apply_feature", d, e).ToStrings("ONE"); strings.Join(have, ",") != "a,b" {
+		t.Errorf("expected applying one locally to set a,b, have %v", have)
+	}
+}
+
+func TestAuditToken(t *testing.T) {
+	auditLog := filepath.Join(os.TempDir(), "countfloyd_test_audit_token")
+	os.Remove(auditLog)
+	defer os.Remove(auditLog)
+	s, dir := testServer(t, "audit",
+		SetAuditLog(auditLog),
+		SetServiceToken("secret", "query"),
+	)
+	socket := filepath.Join(dir, "socket")
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	defer func() {
+		s.Quit()
+		<-served
+	}()
+
+	if resp := send(t, socket, NewRequest(QUERY, ByteAction("audit"), container("AUDIT"))); resp.Error == "" {
+		t.Error("the audit log was served to a query request without the required token")
+	}
+	r := NewRequest(QUERY, ByteAction("audit"), container("AUDIT"))
+	r.Token = "secret"
+	if resp := send(t, socket, r); resp.Error != "" {
+		t.Errorf("an audit query with the required token returned an error: %s", resp.Error)
+	}
+}
+
+func TestPopulateInline(t *testing.T) {
+	s, dir := testServer(t, "inline")
+	socket := filepath.Join(dir, "socket")
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	defer func() {
+		s.Quit()
+		<-served
+	}()
+
+	var features bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&features, "- tag: f%d\n  apply: test_values\n  values: [v%d]\n", i, i)
+	}
+	d := data.New("")
+	d.Set(
+		data.NewStringsItem("inline.features", features.String()),
+		data.NewStringsItem("inline.components", `[{"tag": "c", "features": [{"tag": "f1"}]}]`),
+	)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	framed := NewRequest(DATA, POPULATEINLINE, d).Frame()
+	if len(framed) <= 1024 {
+		t.Fatalf("expected a request larger than a single read, have %d bytes", len(framed))
+	}
+	if _, err := conn.Write(framed); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := NewResponse(b); resp.Error != "" {
+		t.Fatalf("populating inline documents failed: %s", resp.Error)
+	}
+	en, _ := s.Environment("")
+	if en.GetFeature("f99") == nil || len(en.ListComponents()) != 1 {
+		t.Error("inline features and components were not populated")
+	}
+
+	bad := data.New("")
+	bad.Set(data.NewStringsItem("inline.features", "- tag: ["))
+	resp := NewResponse(populateInlineRespond(context.Background(), s, NewRequest(DATA, POPULATEINLINE, bad)))
+	if !strings.HasPrefix(resp.Error, "inline features 1:1: ") {
+		t.Errorf("expected an error located in the inline document, have %q", resp.Error)
+	}
+
+	inc := data.New("")
+	inc.Set(data.NewStringsItem("inline.features", "include: [common.yaml]\nfeatures:\n- tag: g\n  apply: test_values\n  values: [v]\n"))
+	resp = NewResponse(populateInlineRespond(context.Background(), s, NewRequest(DATA, POPULATEINLINE, inc)))
+	if !strings.HasPrefix(resp.Error, "inline features 1 includes common.yaml") {
+		t.Errorf("expected an error for an inline document including files, have %q", resp.Error)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	from, _ := testServer(t, "bundle_from")
+	to, _ := testServer(t, "bundle_to")
+	defer from.Shutdown()
+	defer to.Shutdown()
+
+	d := data.New("")
+	d.Set(
+		data.NewStringsItem("groups", "g"),
+		data.NewStringsItem("inline.features", "- tag: one\n  apply: test_other\n  values: [a, b]\n"),
+		data.NewStringsItem("inline.components", "- tag: c\n  features:\n  - tag: one\n"),
+		data.NewStringsItem("inline.entities", "- tag: en\n  components:\n  - tag: c\n"),
+	)
+	if resp := NewResponse(populateInlineRespond(context.Background(), from, NewRequest(DATA, POPULATEINLINE, d))); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+
+	ed := data.New("")
+	ed.Set(data.NewStringItem("export_group", "g"))
+	resp := NewResponse(exportRespond(context.Background(), from, NewRequest(QUERY, EXPORT, ed)))
+	bundle := resp.Data.ToString("bundle")
+	if resp.Error != "" || !strings.HasPrefix(bundle, "cfb1.") {
+		t.Fatalf("unexpected export: %s %q", resp.Error, bundle)
+	}
+
+	if _, err := feature.DecodeBundle(strings.Replace(bundle, "cfb1.", "cfb9.", 1)); err == nil {
+		t.Error("decoding an unsupported bundle version did not return an error")
+	}
+	spl := strings.SplitN(bundle, ".", 3)
+	if _, err := feature.DecodeBundle(spl[0] + ".0000000000000000." + spl[2]); err == nil {
+		t.Error("decoding a bundle with a wrong checksum did not return an error")
+	}
+	var large bytes.Buffer
+	w := zlib.NewWriter(&large)
+	w.Write(make([]byte, feature.MaxBundleSize+1))
+	w.Close()
+	if _, err := feature.DecodeBundle(spl[0] + "." + spl[1] + "." + base64.StdEncoding.EncodeToString(large.Bytes())); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("decoding a bundle larger than MaxBundleSize did not return a size error: %v", err)
+	}
+
+	id := data.New("")
+	id.Set(data.NewStringsItem("bundles", bundle))
+	if resp := NewResponse(populateInlineRespond(context.Background(), to, NewRequest(DATA, POPULATEINLINE, id))); resp.Error != "" {
+		t.Fatalf("importing a bundle failed: %s", resp.Error)
+	}
+	e, _ := to.Environment("")
+	def, ok := feature.DefinitionOf(e.GetFeature("one"))
+	if !ok || def.Apply != "TEST_OTHER" || strings.Join(def.Values, ",") != "a,b" {
+		t.Errorf("imported feature was not defined with its constructor and values: %+v", def)
+	}
+	if len(e.ListComponents()) != 1 || len(e.ListEntities()) != 1 || len(e.List("g")) != 1 {
+		t.Error("imported bundle did not populate the component, entity and group")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "countfloyd_test_snapshot.yaml")
+	defer os.Remove(file)
+
+	from, _ := testServer(t, "snapshot_from", SetSnapshotFile(file))
+	defer from.Shutdown()
+
+	d := data.New("")
+	d.Set(
+		data.NewStringsItem("groups", "g"),
+		data.NewStringsItem("inline.features", "- tag: turn\n  apply: round_robin\n  values: [a, b, c]\n- tag: kept\n  apply: test_values\n  values: [k]\n"),
+		data.NewStringsItem("inline.components", "- tag: c\n  features:\n  - tag: turn\n"),
+	)
+	if resp := NewResponse(populateInlineRespond(context.Background(), from, NewRequest(DATA, POPULATEINLINE, d))); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if err := from.CreateEnvironment("other"); err != nil {
+		t.Fatal(err)
+	}
+	turn := func(s *Server) string {
+		e, _ := s.Environment("")
+		v := data.New("")
+		e.GetFeature("turn").Map(v)
+		return v.ToString("TURN")
+	}
+	if a, b := turn(from), turn(from); a != "a" || b != "b" {
+		t.Fatalf("unexpected round robin values %s, %s", a, b)
+	}
+
+	resp := NewResponse(snapshotRespond(SNAPSHOT)(context.Background(), from, NewRequest(SYSTEM, SNAPSHOT, nil)))
+	if resp.Error != "" || resp.Data.ToString("snapshot_file") != file {
+		t.Fatalf("unexpected snapshot response: %s %v", resp.Error, resp.Data)
+	}
+
+	to, _ := testServer(t, "snapshot_to", SetRestore(file))
+	defer to.Shutdown()
+	if got := strings.Join(to.Environments(), ","); got != "default,other" {
+		t.Errorf("restored environments %s, not default,other", got)
+	}
+	e, _ := to.Environment("")
+	if src, ok := feature.SourceOf(e.GetFeature("turn")); !ok || src.File != "inline features 1" {
+		t.Errorf("restored feature lost its source: %v", src)
+	}
+	if len(e.List("g")) != 2 || len(e.ListComponents()) != 1 {
+		t.Error("restored environment is missing its group or component")
+	}
+	if def, ok := feature.DefinitionOf(e.GetFeature("kept")); !ok || def.Apply != "TEST_VALUES" {
+		t.Errorf("restored feature was not constructed with the server's constructor: %+v", def)
+	}
+	if got := turn(to); got != "c" {
+		t.Errorf("restored round robin mapped %s, not c", got)
+	}
+
+	b, _ := ioutil.ReadFile(file)
+	ioutil.WriteFile(file, bytes.Replace(b, []byte("version: 1"), []byte("version: 9"), 1), 0644)
+	rd := data.New("")
+	rd.Set(data.NewStringItem("snapshot_file", file))
+	if resp := NewResponse(snapshotRespond(RESTORE)(context.Background(), to, NewRequest(SYSTEM, RESTORE, rd))); resp.Error == "" {
+		t.Error("restoring an unsupported snapshot version did not return an error")
+	}
+	rd.Set(data.NewStringItem("snapshot_file", filepath.Join(os.TempDir(), "elsewhere", "snapshot.yaml")))
+	if resp := NewResponse(snapshotRespond(SNAPSHOT)(context.Background(), to, NewRequest(SYSTEM, SNAPSHOT, rd))); resp.Error == "" {
+		t.Error("a snapshot file outside the configured directory did not return an error")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	s, _ := testServer(t, "checkpoint")
+	defer s.Shutdown()
+
+	populate := func(features string) {
+		d := data.New("")
+		d.Set(
+			data.NewStringsItem("groups", "g"),
+			data.NewStringsItem("inline.features", features),
+		)
+		if resp := NewResponse(populateInlineRespond(context.Background(), s, NewRequest(DATA, POPULATEINLINE, d))); resp.Error != "" {
+			t.Fatal(resp.Error)
+		}
+	}
+	system := func(a Action, items ...data.Item) *Response {
+		d := data.New("")
+		d.Set(items...)
+		return NewResponse(checkpointRespond(a)(context.Background(), s, NewRequest(SYSTEM, a, d)))
+	}
+	turn := func() string {
+		e, _ := s.Environment("")
+		v := data.New("")
+		e.GetFeature("turn").Map(v)
+		return v.ToString("TURN")
+	}
+
+	populate("- tag: turn\n  apply: round_robin\n  values: [a, b, c]\n")
+	checkpointed, _ := s.Environment("")
+	if resp := system(CHECKPOINT, data.NewStringItem("checkpoint_name", "base")); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	if resp := system(CHECKPOINT, data.NewStringItem("checkpoint_name", "base")); resp.Error == "" {
+		t.Error("checkpointing an existing name did not return an error")
+	}
+	if got := turn(); got != "a" {
+		t.Fatalf("unexpected round robin value %s", got)
+	}
+
+	dd := data.New("")
+	dd.Set(data.NewStringsItem("groups", "g"))
+	if resp := NewResponse(depopulateRespond(context.Background(), s, NewRequest(DATA, DEPOPULATE, dd))); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	populate("- tag: turn\n  apply: round_robin\n  values: [a, b]\n- tag: extra\n  apply: round_robin\n  values: [x]\n")
+
+	resp := system(DIFF, data.NewStringItem("diff_from", "base"), data.NewStringItem("diff_to", CurrentCheckpoint))
+	if resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	added, changed := resp.Data.ToStrings("diff.added"), resp.Data.ToStrings("diff.changed")
+	if len(added) != 1 || !strings.HasPrefix(added[0], "feature EXTRA") {
+		t.Errorf("unexpected added: %v", added)
+	}
+	if len(changed) != 1 || !strings.HasPrefix(changed[0], "feature TURN") {
+		t.Errorf("unexpected changed: %v", changed)
+	}
+
+	if resp := system(ROLLBACK, data.NewStringItem("checkpoint_name", "base")); resp.Error != "" {
+		t.Fatal(resp.Error)
+	}
+	e, _ := s.Environment("")
+	if e != checkpointed {
+		t.Error("rolling back constructed the environment again rather than sharing the checkpointed one")
+	}
+	if e.GetFeature("extra") != nil {
+		t.Error("rolled back environment still has a feature added after the checkpoint")
+	}
+	if got := turn(); got != "a" {
+		t.Errorf("rolled back round robin mapped %s, not a as when checkpointed", got)
+	}
+	df, err := s.Diff("", "base", CurrentCheckpoint)
+	if err != nil || len(df.Added)+len(df.Removed)+len(df.Changed) != 0 {
+		t.Errorf("rolled back environment differs from its checkpoint: %v %+v", err, df)
+	}
+
+	if got := system(LISTCHECKPOINTS).Data.ToStrings("checkpoints"); len(got) != 1 || !strings.HasPrefix(got[0], "base ") {
+		t.Errorf("unexpected checkpoints: %v", got)
+	}
+	if resp := system(ROLLBACK, data.NewStringItem("checkpoint_name", "none")); resp.Error == "" {
+		t.Error("rolling back to a missing checkpoint did not return an error")
+	}
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The version of the snapshot file format a server writes.
+const SnapshotVersion = 1
+
+var (
+	NoSnapshotFileError      = xrr.Xrror("no snapshot file configured")
+	SnapshotVersionError     = xrr.Xrror("snapshot %s is version %d, at most version %d is supported").Out
+	SnapshotEnvironmentError = xrr.Xrror("snapshot %s has no default environment").Out
+	SnapshotPathError        = xrr.Xrror("snapshot file %s is not in %s, the directory of the configured snapshot file").Out
+)
+
+// Every environment of a server, as written to a snapshot file.
+type Snapshot struct {
+	Version      int
+	Taken        time.Time
+	Environments map[string]*env.Snapshot
+}
+
+// Returns a Snapshot of every environment.
+func (s *Server) Snapshot() (*Snapshot, error) {
+	s.envs.mu.RLock()
+	has := map[string]env.Env{DefaultEnvironment: s.Env}
+	for k, e := range s.envs.has {
+		has[k] = e
+	}
+	s.envs.mu.RUnlock()
+
+	ret := &Snapshot{
+		Version:      SnapshotVersion,
+		Taken:        time.Now().UTC(),
+		Environments: make(map[string]*env.Snapshot),
+	}
+	for k, e := range has {
+		es, err := env.NewSnapshot(e)
+		if err != nil {
+			return nil, err
+		}
+		ret.Environments[k] = es
+	}
+	return ret, nil
+}
+
+// Writes a Snapshot of every environment to the provided file, replacing it
+// only once completely written.
+func (s *Server) WriteSnapshot(file string) error {
+	sn, err := s.Snapshot()
+	if err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(sn)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Reads a Snapshot from the provided file, returning an error for an
+// unsupported version.
+func ReadSnapshot(file string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sn := &Snapshot{}
+	if err := yaml.Unmarshal(b, sn); err != nil {
+		return nil, err
+	}
+	if sn.Version < 1 || sn.Version > SnapshotVersion {
+		return nil, SnapshotVersionError(file, sn.Version, SnapshotVersion)
+	}
+	if _, ok := sn.Environments[DefaultEnvironment]; !ok {
+		return nil, SnapshotEnvironmentError(file)
+	}
+	return sn, nil
+}
+
+// Replaces every environment with those of the snapshot in the provided file,
+// only if every environment restores without error. Each environment is
+// restored with a copy of the constructors of the live environment of the
+// same name, or of the default environment.
+func (s *Server) Restore(ctx context.Context, file string) error {
+	sn, err := ReadSnapshot(file)
+	if err != nil {
+		return err
+	}
+	s.envs.mu.RLock()
+	live := map[string]env.Env{DefaultEnvironment: s.Env}
+	for k, e := range s.envs.has {
+		live[k] = e
+	}
+	s.envs.mu.RUnlock()
+	has := make(map[string]env.Env)
+	for k, es := range sn.Environments {
+		cs, ok := live[k]
+		if !ok {
+			cs = live[DefaultEnvironment]
+		}
+		e, err := env.Restore(ctx, es, env.SetConstructorRegistry(feature.CopyConstructors(cs)))
+		if err != nil {
+			return err
+		}
+		has[k] = e
+	}
+
+	s.envs.staging.Lock()
+	defer s.envs.staging.Unlock()
+	s.envs.mu.Lock()
+	defer s.envs.mu.Unlock()
+	s.Env = has[DefaultEnvironment]
+	delete(has, DefaultEnvironment)
+	s.envs.has = has
+	return nil
+}
+
+// Writes a snapshot to the configured snapshot file at every snapshot interval
+// until the context is done.
+func (s *Server) autoSnapshot(ctx context.Context) {
+	tick := time.NewTicker(s.SnapshotInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := s.WriteSnapshot(s.SnapshotFile); err != nil {
+				s.Printf("snapshot error: %s", err)
+			}
+		}
+	}
+}
+
+func autoSnapshots(s *Server) bool {
+	return s.SnapshotFile != "" && s.SnapshotInterval > 0
+}
+
+// The snapshot file a request provides with snapshot_file, or that configured.
+// A request may only name a file in the directory of the configured file.
+func snapshotFile(s *Server, d *data.Vector) (string, error) {
+	if s.SnapshotFile == "" {
+		return "", NoSnapshotFileError
+	}
+	f := d.ToString("snapshot_file")
+	if f == "" {
+		return s.SnapshotFile, nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(s.SnapshotFile))
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(f)
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(abs) != dir {
+		return "", SnapshotPathError(f, dir)
+	}
+	return abs, nil
+}
+
+func snapshotRespond(a Action) HandlerFunc {
+	return func(ctx context.Context, s *Server, r *Request) []byte {
+		d := r.Data
+		if d == nil {
+			d = data.New("")
+		}
+		file, err := snapshotFile(s, d)
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
+		switch {
+		case actionIs(a, SNAPSHOT):
+			err = s.WriteSnapshot(file)
+		case actionIs(a, RESTORE):
+			err = s.Restore(ctx, file)
+		}
+		if err != nil {
+			return ErrorResponse(err).ToByte()
+		}
+		resp := EmptyResponse()
+		d.Set(data.NewStringItem("snapshot_file", file))
+		d.Set(data.NewStringsItem("environments", s.Environments()...))
+		resp.Data = d
+		return resp.ToByte()
+	}
+}
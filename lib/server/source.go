@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/data"
+)
+
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	aa, _ := filepath.Abs(a)
+	ab, _ := filepath.Abs(b)
+	return aa == ab
+}
+
+func sourceEntry(tag string, s feature.Source) string {
+	ret := tag
+	if s.Line > 0 {
+		ret = fmt.Sprintf("%s line %d", ret, s.Line)
+	}
+	if s.Parent != "" {
+		ret = fmt.Sprintf("%s derived from %s", ret, s.Parent)
+	}
+	return ret
+}
+
+// Lists the features, components and entities defined by the file or plugin
+// provided with query_source.
+func sourceData(e env.Env, d *data.Vector) *data.Vector {
+	q := d.ToString("query_source")
+	defined := func(i interface{}, tag string, to *[]string) {
+		if src, ok := feature.SourceOf(i); ok {
+			if sameFile(src.File, q) || sameFile(src.Plugin, q) {
+				*to = append(*to, sourceEntry(tag, src))
+			}
+		}
+	}
+
+	var fs, cs, es []string
+	for _, rf := range e.ListAll() {
+		defined(e.GetFeature(rf.Tag), rf.Tag, &fs)
+	}
+	for _, c := range e.ListComponents() {
+		defined(c, c.Tag(), &cs)
+	}
+	for _, en := range e.ListEntities() {
+		defined(en, en.Tag(), &es)
+	}
+	sort.Strings(fs)
+	sort.Strings(cs)
+	sort.Strings(es)
+
+	d.Set(
+		data.NewStringsItem("source.features", fs...),
+		data.NewStringsItem("source.components", cs...),
+		data.NewStringsItem("source.entities", es...),
+	)
+	return d
+}
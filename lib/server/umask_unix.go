@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// Creates sockets readable and writable by the server user only until the
+// returned func restores the umask, returning also the mode a socket has
+// under the umask restored.
+func restrictSocket() (func(), os.FileMode) {
+	old := syscall.Umask(0177)
+	return func() { syscall.Umask(old) }, os.FileMode(0777 &^ old)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package server
+
+import "os"
+
+// There is no umask on windows.
+func restrictSocket() (func(), os.FileMode) {
+	return func() {}, 0
+}
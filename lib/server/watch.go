@@ -0,0 +1,375 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+// The default interval watched files are polled for changes.
+var defaultWatchInterval = time.Second
+
+var (
+	WatchKindError         = xrr.Xrror("cannot watch %s, only features, components or entities").Out
+	EnvironmentChangeError = xrr.Xrror("environment %s changed while being replaced").Out
+)
+
+// The tags of features, components and entities a file contributed.
+type contribution struct {
+	features, components, entities []string
+}
+
+func contents(e env.Env) contribution {
+	var c contribution
+	for _, rf := range e.ListAll() {
+		c.features = append(c.features, rf.Tag)
+	}
+	for _, cm := range e.ListComponents() {
+		c.components = append(c.components, cm.Tag())
+	}
+	for _, en := range e.ListEntities() {
+		c.entities = append(c.entities, en.Tag())
+	}
+	return c
+}
+
+func added(before, after []string) []string {
+	had := make(map[string]bool)
+	for _, t := range before {
+		had[t] = true
+	}
+	var ret []string
+	for _, t := range after {
+		if !had[t] {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+func existing(tags, in []string) []string {
+	has := make(map[string]bool)
+	for _, t := range in {
+		has[t] = true
+	}
+	var ret []string
+	for _, t := range tags {
+		if has[t] {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// The tags of the features defined, not only referenced.
+func rawTags(rfs []*feature.RawFeature) []string {
+	var ret []string
+	for _, rf := range rfs {
+		if rf.Reference() {
+			continue
+		}
+		ret = append(ret, strings.ToUpper(rf.Tag))
+	}
+	return ret
+}
+
+func componentTags(c *contribution, rcs []*feature.RawComponent) {
+	for _, rc := range rcs {
+		c.components = append(c.components, rc.Tag)
+		c.features = append(c.features, rawTags(rc.Defines)...)
+		c.features = append(c.features, rawTags(rc.Features)...)
+	}
+}
+
+// Parses the tags a file of the provided kind defines.
+func fileTags(kind, path string, b []byte) (contribution, error) {
+	var c contribution
+	switch kind {
+	case "features":
+		rfs, err := feature.ParseFeatures(path, b)
+		if err != nil {
+			return c, err
+		}
+		c.features = rawTags(rfs)
+	case "components":
+		rcs, err := feature.ParseComponents(path, b)
+		if err != nil {
+			return c, err
+		}
+		componentTags(&c, rcs)
+	case "entities":
+		res, err := feature.ParseEntities(path, b)
+		if err != nil {
+			return c, err
+		}
+		for _, re := range res {
+			c.entities = append(c.entities, re.Tag)
+			c.features = append(c.features, rawTags(re.Defines)...)
+			componentTags(&c, re.Components)
+		}
+	default:
+		return c, WatchKindError(kind)
+	}
+	return c, nil
+}
+
+func populateKind(ctx context.Context, e env.Env, kind string, groups []string, path string) error {
+	switch kind {
+	case "features":
+		return e.PopulateFeatureYaml(ctx, groups, path)
+	case "components":
+		return e.PopulateComponentYaml(ctx, groups, path)
+	case "entities":
+		return e.PopulateEntityYaml(ctx, groups, path)
+	}
+	return WatchKindError(kind)
+}
+
+type watched struct {
+	path, kind, env string
+	groups          []string
+	mod             time.Time
+	size            int64
+	owns            contribution
+	err             error
+}
+
+type watchedDir struct {
+	path, kind, env string
+	groups          []string
+}
+
+// Watched files and directories. All watching, including reloads, happens
+// holding the lock.
+type watcher struct {
+	mu    sync.Mutex
+	files map[string]*watched
+	dirs  map[string]*watchedDir
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		files: make(map[string]*watched),
+		dirs:  make(map[string]*watchedDir),
+	}
+}
+
+// Watches the files, or definition files within directories, at the provided paths
+// for changes, loading each file now and again whenever it changes.
+func (s *Server) Watch(ctx context.Context, kind, envName string, groups []string, paths ...string) error {
+	if _, err := fileTags(kind, "", nil); err != nil {
+		return err
+	}
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	var errs []string
+	for _, p := range paths {
+		p, _ = filepath.Abs(p)
+		fi, err := os.Stat(p)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			s.watcher.dirs[p] = &watchedDir{p, kind, envName, groups}
+			files = dirFiles(p)
+		}
+		for _, f := range files {
+			if err := s.watchFile(ctx, kind, envName, groups, f); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func dirFiles(dir string) []string {
+	var ret []string
+	fis, _ := ioutil.ReadDir(dir)
+	for _, fi := range fis {
+		if !fi.IsDir() && feature.DefinitionFile(fi.Name()) {
+			ret = append(ret, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return ret
+}
+
+// Registers a single file, taking ownership of any tags it defines that are
+// already loaded, e.g. by an earlier populate of the same file.
+func (s *Server) watchFile(ctx context.Context, kind, envName string, groups []string, path string) error {
+	if _, ok := s.watcher.files[path]; ok {
+		return nil
+	}
+	w := &watched{path: path, kind: kind, env: envName, groups: groups}
+	s.watcher.files[path] = w
+
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if e, err := s.Environment(envName); err == nil {
+			if ft, err := fileTags(kind, path, b); err == nil {
+				have := contents(e)
+				w.owns = contribution{
+					existing(ft.features, have.features),
+					existing(ft.components, have.components),
+					existing(ft.entities, have.entities),
+				}
+			}
+		}
+	}
+
+	return s.reload(ctx, w)
+}
+
+// Reloads a watched file into a staged copy of its environment.
+func (s *Server) reload(ctx context.Context, w *watched) (err error) {
+	defer func() {
+		w.err = err
+		if err != nil {
+			s.Printf("reloading %s: %s", w.path, err)
+		}
+	}()
+
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	w.mod, w.size = fi.ModTime(), fi.Size()
+
+	b, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+	if _, err := fileTags(w.kind, w.path, b); err != nil {
+		return err
+	}
+
+	var before, after contribution
+	err = s.stage(ctx, w.env, true, func(scratch env.Env) error {
+		scratch.RemoveFeature(w.owns.features...)
+		scratch.RemoveComponent(w.owns.components...)
+		scratch.RemoveEntity(w.owns.entities...)
+		before = contents(scratch)
+		if err := populateKind(ctx, scratch, w.kind, w.groups, w.path); err != nil {
+			return err
+		}
+		after = contents(scratch)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.owns = contribution{
+		added(before.features, after.features),
+		added(before.components, after.components),
+		added(before.entities, after.entities),
+	}
+	s.Printf("reloaded %s", w.path)
+	return nil
+}
+
+func (s *Server) changed() []*watched {
+	var ret []*watched
+	for _, w := range s.watcher.files {
+		fi, err := os.Stat(w.path)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(w.mod) || fi.Size() != w.size {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+func (s *Server) poll(ctx context.Context) {
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+
+	for _, d := range s.watcher.dirs {
+		for _, f := range dirFiles(d.path) {
+			s.watchFile(ctx, d.kind, d.env, d.groups, f)
+		}
+	}
+
+	for _, w := range s.changed() {
+		s.reload(ctx, w)
+	}
+}
+
+// Polls watched files for changes until the provided context is done.
+func (s *Server) watch(ctx context.Context) {
+	interval := s.WatchInterval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			s.poll(ctx)
+		}
+	}
+}
+
+func watchData(s *Server, d *data.Vector) *data.Vector {
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	var files, errs []string
+	for _, w := range s.watcher.files {
+		en := w.env
+		if en == "" {
+			en = DefaultEnvironment
+		}
+		files = append(files, fmt.Sprintf("%s %s %s", w.path, w.kind, en))
+		if w.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", w.path, w.err))
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(errs)
+	d.Set(data.NewStringsItem("watch.files", files...))
+	d.Set(data.NewStringsItem("watch.errors", errs...))
+	return d
+}
+
+func watchRespond(ctx context.Context, s *Server, r *Request) []byte {
+	d := r.Data
+	if d == nil {
+		d = data.New("")
+	}
+	resp := EmptyResponse()
+	envName := d.ToString("meta.env")
+	if _, err := s.Environment(envName); err != nil {
+		return ErrorResponse(err).ToByte()
+	}
+	groups := d.ToStrings("groups")
+	var errs []string
+	for _, kind := range []string{"features", "components", "entities"} {
+		if paths := d.ToStrings(kind); len(paths) > 0 {
+			if err := s.Watch(ctx, kind, envName, groups, paths...); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	resp.Error = strings.Join(errs, "; ")
+	resp.Data = watchData(s, d)
+	return resp.ToByte()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laughs-In-Flowers/flip"
+	"github.com/Laughs-In-Flowers/xrr"
+)
+
+type lOptions struct {
+	lWait        bool
+	lWaitTimeout time.Duration
+	lLogFile     string
+	lPidFile     string
+	lWatch       bool
+}
+
+func lifecycleFlags(o *Options, fs *flip.FlagSet) {
+	fs.BoolVar(&o.lWait, "wait", o.lWait, "Block until the server is ready, or stopped.")
+	fs.DurationVar(&o.lWaitTimeout, "waitTimeout", o.lWaitTimeout, "How long to block with -wait before giving up.")
+	fs.StringVar(&o.lPidFile, "pidFile", o.lPidFile, "The server pid file, defaulting to the socket path with a .pid extension.")
+}
+
+var (
+	RunningError    = xrr.Xrror("a countfloyd server is already running at %s").Out
+	NotReadyError   = xrr.Xrror("countfloyd server at %s not ready after %s").Out
+	ExitedError     = xrr.Xrror("countfloyd server exited before ready: %v").Out
+	NotStoppedError = xrr.Xrror("countfloyd server at %s still running after %s").Out
+)
+
+var pollInterval = 100 * time.Millisecond
+
+// Returns nil if the server responds to a ping.
+func ping(s *sonnect) error {
+	resp, _, err := exchange(s, "system", "ping", nil)
+	if err != nil {
+		return err
+	}
+	sresp, err := unmarshal(resp)
+	if err != nil {
+		return err
+	}
+	if sresp.Error != "" {
+		return errors.New(sresp.Error)
+	}
+	return nil
+}
+
+func pidPath(o *Options, s *sonnect) string {
+	if o.lPidFile != "" {
+		return o.lPidFile
+	}
+	return s.socket + ".pid"
+}
+
+func readPid(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func startArgs(o *Options, s *sonnect) []string {
+	cs := []string{
+		"-socket", s.socket,
+		"-logFormatter", s.formatter,
+		"-pidFile", pidPath(o, s),
+	}
+	if o.lWatch {
+		cs = append(cs, "-watch")
+	}
+	cs = getStartPopulate(cs, o)
+	return append(cs, "start")
+}
+
+// Starts a detached cfs process, blocking until it responds to a ping when
+// waiting is requested.
+func startServer(o *Options, s *sonnect) error {
+	if ping(s) == nil {
+		return RunningError(s.socket)
+	}
+
+	cmd := exec.Command("cfs", startArgs(o, s)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if o.lLogFile != "" {
+		f, err := os.OpenFile(o.lLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdout, cmd.Stderr = f, f
+	}
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if !o.lWait {
+		return cmd.Process.Release()
+	}
+
+	return waitStarted(cmd, s, o.lWaitTimeout)
+}
+
+func waitStarted(cmd *exec.Cmd, s *sonnect, limit time.Duration) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.After(limit)
+	tick := time.NewTicker(pollInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case err := <-exited:
+			return ExitedError(err)
+		case <-deadline:
+			return NotReadyError(s.socket, limit)
+		case <-tick.C:
+			if ping(s) == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// A server is stopped when it no longer responds and has removed its socket,
+// or its socket is stale with no live process behind it.
+func stopped(s *sonnect, pidFile string) bool {
+	if ping(s) == nil {
+		return false
+	}
+	if _, err := os.Stat(s.socket); os.IsNotExist(err) {
+		return true
+	}
+	pid, err := readPid(pidFile)
+	return err != nil || !alive(pid)
+}
+
+// Quits a server, blocking until it is stopped when waiting.
+func stopServer(o *Options, s *sonnect, wait bool) error {
+	if _, _, err := exchange(s, "system", "quit", nil); err != nil {
+		return err
+	}
+
+	if !wait {
+		return nil
+	}
+
+	pf := pidPath(o, s)
+	deadline := time.Now().Add(o.lWaitTimeout)
+	for time.Now().Before(deadline) {
+		if stopped(s, pf) {
+			return nil
+		}
+		time.Sleep(pollInterval)
+	}
+	return NotStoppedError(s.socket, o.lWaitTimeout)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	"github.com/Laughs-In-Flowers/flip"
+)
+
+type lintOptions struct {
+	*pOptions
+	strict bool
+}
+
+func lintConfig(o *lintOptions) []env.Config {
+	var ret []env.Config
+	if o.pConstructorPlugin != "" {
+		ret = append(ret, env.SetConstructorPlugin(strings.Split(o.pConstructorPlugin, ",")...))
+	}
+	if o.pFeaturePlugin != "" {
+		ret = append(ret, env.SetFeaturePlugin(nil, strings.Split(o.pFeaturePlugin, ",")...))
+	}
+	return ret
+}
+
+// Expands comma separated files and directories to the files to lint. Unlike
+// populating, a missing file is kept so that it is reported.
+func lintFiles(in string) []string {
+	var ret []string
+	if in == "" {
+		return ret
+	}
+	for _, p := range strings.Split(in, ",") {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			fis, _ := ioutil.ReadDir(p)
+			for _, f := range fis {
+				if !f.IsDir() && feature.DefinitionFile(f.Name()) {
+					ret = append(ret, filepath.Join(p, f.Name()))
+				}
+			}
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// Checks definition files without a server, exiting with failure if any
+// problem is an error, or with strict, a warning.
+func LintCommand() flip.Command {
+	o := &lintOptions{pOptions: &pOptions{}}
+	fs := func(o *lintOptions) *flip.FlagSet {
+		fs := flip.NewFlagSet("lint", flip.ContinueOnError)
+		fs.StringVar(&o.pConstructorPlugin, "constructorPlugin", o.pConstructorPlugin, "Comma separated string list of directories containing Constructor plugins.")
+		fs.StringVar(&o.pFeaturePlugin, "featurePlugin", o.pFeaturePlugin, "Comma separated string list of directories containing Feature plugins.")
+		fs.BoolVar(&o.strict, "strict", o.strict, "Fail on warnings, e.g. unused features, as well as errors.")
+		filesFlags(&Options{pOptions: o.pOptions}, fs)
+		varsFlags(o.pOptions, fs)
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"lint",
+		"check feature, component and entity files for problems without loading them into a server.",
+		1,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			files := env.LintFiles{
+				Features:   lintFiles(o.pFeature),
+				Components: lintFiles(o.pComponent),
+				Entities:   lintFiles(o.pEntity),
+			}
+			kvs, err := requestVars(o.pOptions, &env.Project{})
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitUsageError
+			}
+			vars, _ := feature.ParseVars(kvs...)
+			problems, err := env.Lint(feature.WithVars(c, vars), files, lintConfig(o)...)
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitFailure
+			}
+			for _, p := range problems {
+				L.Print(p)
+			}
+			if env.HasErrors(problems) || (o.strict && len(problems) > 0) {
+				return c, flip.ExitFailure
+			}
+			return c, flip.ExitSuccess
+		},
+		fs,
+	)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/server"
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/flip"
+)
+
+func localFlags(o *Options, fs *flip.FlagSet) {
+	fs.BoolVar(&o.aLocal, "local", o.aLocal, "Apply in process from the provided files and plugins, without a server.")
+	fs.StringVar(&o.pFeature, "feature-files", o.pFeature, "With -local, populate features from files or directories.")
+	fs.StringVar(&o.pComponent, "component-files", o.pComponent, "With -local, populate components from files or directories.")
+	fs.StringVar(&o.pEntity, "entity-files", o.pEntity, "With -local, populate entities from files or directories.")
+	fs.StringVar(&o.pConstructorPlugin, "constructorPlugin", o.pConstructorPlugin, "With -local, comma separated string list of directories containing Constructor plugins.")
+	fs.StringVar(&o.pFeaturePlugin, "featurePlugin", o.pFeaturePlugin, "With -local, comma separated string list of directories containing Feature plugins.")
+	fs.StringVar(&o.pGroup, "group", o.pGroup, "With -local, comma separated string list of set tags to apply to all features read in.")
+}
+
+// Populates an Env in process, as a server would from a populate request, then
+// applies and stores the result as a server would an apply request.
+func applyLocal(ctx context.Context, o *Options, action string, d *data.Vector) flip.ExitStatus {
+	e, err := env.New()
+	if err != nil {
+		return onError("local", action, "environment", err)
+	}
+
+	_, pd, err := populateVector(o)
+	if err != nil {
+		L.Print(err)
+		return flip.ExitUsageError
+	}
+	if err := server.Populate(ctx, e, pd); err != nil {
+		return onError("local", action, "populate", err)
+	}
+
+	if err := store(server.Apply(ctx, action, d, e)); err != nil {
+		return onError("local", action, "store", err)
+	}
+
+	return onSuccess("local", action)
+}
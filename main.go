@@ -7,14 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/Laughs-In-Flowers/countfloyd/lib/env"
+	"github.com/Laughs-In-Flowers/countfloyd/lib/feature"
+	_ "github.com/Laughs-In-Flowers/countfloyd/lib/feature/constructors_common"
 	"github.com/Laughs-In-Flowers/countfloyd/lib/server"
 	"github.com/Laughs-In-Flowers/data"
 	"github.com/Laughs-In-Flowers/flip"
@@ -28,6 +32,7 @@ type Options struct {
 	*pOptions
 	*qOptions
 	*aOptions
+	*lOptions
 }
 
 func NewOptions() *Options {
@@ -36,25 +41,38 @@ func NewOptions() *Options {
 			LocalPath:  "/tmp/cfc",
 			SocketPath: "/tmp/countfloyd_0_0-socket",
 			Timeout:    2 * time.Second,
+			Token:      os.Getenv("COUNTFLOYD_TOKEN"),
 		},
-		pOptions: &pOptions{},
+		pOptions: &pOptions{pKind: "features"},
 		qOptions: &qOptions{},
 		aOptions: &aOptions{
 			aStore:    "stdout",
 			aLocation: currentLoc,
 		},
+		lOptions: &lOptions{
+			lWaitTimeout: 10 * time.Second,
+		},
 	}
 }
 
 type sOptions struct {
 	LocalPath, SocketPath string
 	Timeout               time.Duration
+	Token                 string
+	Env                   string
 }
 
 type pOptions struct {
 	pFeature, pComponent, pEntity      string
 	pConstructorPlugin, pFeaturePlugin string
 	pGroup                             string
+	pInline, pStdin                    bool
+	pKind, pShare                      string
+	pBundle                            string
+	pLoad                              string
+	pPatch                             string
+	pProject                           string
+	pVar, pVarsFile                    string
 }
 
 func pathError(e error) bool {
@@ -93,30 +111,43 @@ func parseDirFiles(in string) []string {
 	return ret
 }
 
+func splitNonEmpty(in string) []string {
+	if in == "" {
+		return nil
+	}
+	return strings.Split(in, ",")
+}
+
 func (o *Options) files(tag string) []string {
 	switch tag {
 	case "constructor-plugin":
-		return strings.Split(tag, ",")
+		return splitNonEmpty(o.pConstructorPlugin)
 	case "feature-plugin":
-		return strings.Split(tag, ",")
+		return splitNonEmpty(o.pFeaturePlugin)
 	case "features":
 		return parseDirFiles(o.pFeature)
 	case "components":
 		return parseDirFiles(o.pComponent)
 	case "entities":
 		return parseDirFiles(o.pEntity)
+	case "patches":
+		return parseDirFiles(o.pPatch)
 	}
 	return nil
 }
 
 type qOptions struct {
 	qFeature, qComponent, qEntity string
+	qSource                       string
+	qStats                        bool
+	qAudit                        int
 }
 
 type aOptions struct {
 	aNumber                       float64
 	aFeature, aComponent, aEntity string
 	aStore, aLocation             string
+	aLocal                        bool
 }
 
 var MoreThanAllowableError = xrr.Xrror("Can only request one of feature, component, or entity: %v").Out
@@ -151,6 +182,18 @@ func (o *Options) Act(d *data.Vector, single bool) (string, *data.Vector, error)
 		action = "query_entity"
 		aSwitch[action] = true
 		d.Set(data.NewStringItem("query_entity", o.qEntity))
+	case o.qSource != "":
+		action = "query_source"
+		aSwitch[action] = true
+		src, _ := filepath.Abs(o.qSource)
+		d.Set(data.NewStringItem("query_source", src))
+	case o.qStats:
+		action = "stats"
+		aSwitch[action] = true
+	case o.qAudit > 0:
+		action = "audit"
+		aSwitch[action] = true
+		d.Set(data.NewIntItem("audit_lines", o.qAudit))
 	}
 	if single {
 		var acount []string
@@ -182,6 +225,8 @@ func init() {
 type sonnect struct {
 	formatter, local, socket string
 	timeout                  time.Duration
+	token                    string
+	env                      string
 }
 
 var Sonnect *sonnect
@@ -189,6 +234,9 @@ var Sonnect *sonnect
 func socketFlags(o *Options, fs *flip.FlagSet) {
 	fs.StringVar(&o.LocalPath, "local", o.LocalPath, "Specify a local path for communication to the server.")
 	fs.StringVar(&o.SocketPath, "socket", o.SocketPath, "Specify the socket path of the server.")
+	fs.DurationVar(&o.Timeout, "timeout", o.Timeout, "Specify how long to wait on the server before giving up.")
+	fs.StringVar(&o.Token, "token", o.Token, "Specify a token for restricted server services, defaulting to $COUNTFLOYD_TOKEN.")
+	fs.StringVar(&o.Env, "env", o.Env, "Specify the named server environment requests act on.")
 }
 
 func TopCommand() flip.Command {
@@ -216,7 +264,7 @@ func TopCommand() flip.Command {
 					L.SwapFormatter(log.GetFormatter(o.LogFormatter))
 				}
 			}
-			Sonnect = &sonnect{o.LogFormatter, o.LocalPath, o.SocketPath, o.Timeout}
+			Sonnect = &sonnect{o.LogFormatter, o.LocalPath, o.SocketPath, o.Timeout, o.Token, o.Env}
 			return c, flip.ExitNo
 		},
 		fs,
@@ -248,27 +296,51 @@ func isQuit(service, action string) flip.ExitStatus {
 	return flip.ExitSuccess
 }
 
-func connect(s *sonnect, service, action string, d *data.Vector) flip.ExitStatus {
+// Writes a request to the server, returning the raw response, or the point of
+// failure and an error.
+func exchange(s *sonnect, service, action string, d *data.Vector) ([]byte, string, error) {
+	if s.env != "" {
+		if d == nil {
+			d = data.New("")
+		}
+		d.Set(data.NewStringItem("meta.env", s.env))
+	}
 	req := server.NewRequest(
 		server.ByteService(service),
 		server.ByteAction(action),
 		d,
 	)
+	req.Token = s.token
 
 	conn, cErr := connection(s.local, s.socket)
 	defer cleanup(conn, s.local)
 	if cErr != nil {
-		return onError(service, action, "connection", cErr)
+		return nil, "connection", cErr
 	}
 
-	_, wErr := conn.Write(req.ToByte())
+	if s.timeout > 0 {
+		if dErr := conn.SetDeadline(time.Now().Add(s.timeout)); dErr != nil {
+			return nil, "connection", dErr
+		}
+	}
+
+	_, wErr := conn.Write(req.Frame())
 	if wErr != nil {
-		return onError(service, action, "write", wErr)
+		return nil, "write", wErr
 	}
 
-	resp, rErr := response(conn, s.timeout)
+	resp, rErr := response(conn)
 	if rErr != nil {
-		return onError(service, action, "response", rErr)
+		return nil, "response", rErr
+	}
+
+	return resp, "", nil
+}
+
+func connect(s *sonnect, service, action string, d *data.Vector) flip.ExitStatus {
+	resp, point, err := exchange(s, service, action, d)
+	if err != nil {
+		return onError(service, action, point, err)
 	}
 
 	if action == "quit" {
@@ -305,19 +377,16 @@ func connection(local, socket string) (*net.UnixConn, error) {
 
 var ResponseError = xrr.Xrror("Error getting a response from the countfloyd server: %s").Out
 
-func response(c io.Reader, timeout time.Duration) ([]byte, error) {
-	t := time.After(timeout)
-	for {
-		select {
-		case <-t:
+func response(c io.Reader) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_, err := io.Copy(buf, c)
+	if err != nil {
+		if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
 			return nil, ResponseError("time out")
-		default:
-			buf := new(bytes.Buffer)
-			io.Copy(buf, c)
-			return buf.Bytes(), nil
 		}
+		return nil, ResponseError(err)
 	}
-	return nil, ResponseError("no response")
+	return buf.Bytes(), nil
 }
 
 func unmarshal(b []byte) (*server.Response, error) {
@@ -374,11 +443,18 @@ func filesFlags(o *Options, fs *flip.FlagSet) {
 	fs.StringVar(&o.pEntity, "entity", "", "Populate entities from files or directories.")
 }
 
+func startFlags(o *Options, fs *flip.FlagSet) {
+	filesFlags(o, fs)
+	lifecycleFlags(o, fs)
+	fs.StringVar(&o.lLogFile, "log", o.lLogFile, "Write server output to this file instead of stdout.")
+	fs.BoolVar(&o.lWatch, "watch", o.lWatch, "Reload the populated files whenever they change.")
+}
+
 func StartCommand() flip.Command {
 	o := NewOptions()
 	fs := func(o *Options) *flip.FlagSet {
 		fs := flip.NewFlagSet("", flip.ContinueOnError)
-		filesFlags(o, fs)
+		startFlags(o, fs)
 		return fs
 	}(o)
 	return flip.NewCommand(
@@ -388,14 +464,8 @@ func StartCommand() flip.Command {
 		1,
 		false,
 		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
-			cs := []string{"-socket", Sonnect.socket, "-logFormatter", Sonnect.formatter}
-			cs = getStartPopulate(cs, o)
-			cs = append(cs, "start")
-			cmd := exec.Command("cfs", cs...)
-			cmd.Stdout = os.Stdout
-			err := cmd.Start()
-			if err != nil {
-				return c, flip.ExitFailure
+			if err := startServer(o, Sonnect); err != nil {
+				return c, onError("system", "start", "start", err)
 			}
 			return c, flip.ExitSuccess
 		},
@@ -407,6 +477,7 @@ func end(v string) flip.Command {
 	o := NewOptions()
 	fs := func(o *Options) *flip.FlagSet {
 		fs := flip.NewFlagSet(v, flip.ContinueOnError)
+		lifecycleFlags(o, fs)
 		return fs
 	}(o)
 	return flip.NewCommand(
@@ -416,7 +487,10 @@ func end(v string) flip.Command {
 		2,
 		false,
 		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
-			return c, connect(Sonnect, "system", "quit", nil)
+			if err := stopServer(o, Sonnect, o.lWait); err != nil {
+				return c, onError("system", "quit", "stop", err)
+			}
+			return c, isQuit("system", "quit")
 		},
 		fs,
 	)
@@ -430,6 +504,34 @@ func QuitCommand() flip.Command {
 	return end("quit")
 }
 
+func RestartCommand() flip.Command {
+	o := NewOptions()
+	fs := func(o *Options) *flip.FlagSet {
+		fs := flip.NewFlagSet("restart", flip.ContinueOnError)
+		startFlags(o, fs)
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"restart",
+		"stop a running countfloyd server, then start a new one",
+		2,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			if ping(Sonnect) == nil {
+				if err := stopServer(o, Sonnect, true); err != nil {
+					return c, onError("system", "restart", "stop", err)
+				}
+			}
+			if err := startServer(o, Sonnect); err != nil {
+				return c, onError("system", "restart", "start", err)
+			}
+			return c, flip.ExitSuccess
+		},
+		fs,
+	)
+}
+
 func StatusCommand() flip.Command {
 	o := NewOptions()
 	fs := func(o *Options) *flip.FlagSet {
@@ -453,6 +555,9 @@ func queryFlags(o *Options, fs *flip.FlagSet) {
 	fs.StringVar(&o.qFeature, "feature", o.qFeature, "return information for this specified feature")
 	fs.StringVar(&o.qComponent, "component", o.qComponent, "return information for this specified component")
 	fs.StringVar(&o.qEntity, "entity", o.qEntity, "return information for this specified entity")
+	fs.StringVar(&o.qSource, "source", o.qSource, "return everything defined by this specified file or plugin")
+	fs.IntVar(&o.qAudit, "audit", o.qAudit, "return this many of the most recent server audit log entries")
+	fs.BoolVar(&o.qStats, "stats", o.qStats, "return server request, emit, populate and registry statistics")
 }
 
 func queryVector(o *Options) (string, *data.Vector, error) {
@@ -488,15 +593,134 @@ func QueryCommand() flip.Command {
 	)
 }
 
+var (
+	InlinePluginError  = xrr.Xrror("plugins are read from the server's filesystem and cannot be sent inline").Out
+	KindError          = xrr.Xrror("unknown kind %s, expected features, components, entities or patches").Out
+	ProjectInlineError = xrr.Xrror("a project lists files the server reads and cannot be combined with -inline, -stdin or -share").Out
+)
+
+// Sends the content of files, standard input, and share strings in the
+// request, rather than paths the server reads.
+func populateInlineVector(o *Options, d *data.Vector) (string, *data.Vector, error) {
+	if o.pConstructorPlugin != "" || o.pFeaturePlugin != "" {
+		return "", nil, InlinePluginError()
+	}
+	docs := make(map[string][]string)
+	if o.pInline {
+		for _, kind := range []string{"features", "components", "entities", "patches"} {
+			for _, f := range o.files(kind) {
+				b, err := ioutil.ReadFile(f)
+				if err != nil {
+					return "", nil, err
+				}
+				docs[kind] = append(docs[kind], string(b))
+			}
+		}
+	}
+	if o.pStdin {
+		switch o.pKind {
+		case "features", "components", "entities", "patches":
+		default:
+			return "", nil, KindError(o.pKind)
+		}
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", nil, err
+		}
+		docs[o.pKind] = append(docs[o.pKind], string(b))
+	}
+	for kind, ds := range docs {
+		d.Set(data.NewStringsItem("inline."+kind, ds...))
+	}
+	d.Set(data.NewStringsItem("shares", splitNonEmpty(o.pShare)...))
+	d.SetStrings("groups", o.pGroup)
+	d.SetString("load", o.pLoad)
+	return "populate_inline", d, nil
+}
+
+// The prefix of environment variables substituted as variables of
+// definition documents, e.g. COUNTFLOYD_VAR_difficulty.
+const varEnvPrefix = "COUNTFLOYD_VAR_"
+
+// The name=value variables substituted in populated documents: those of a
+// project, then of a vars file, the environment and -var, each over the last.
+func requestVars(o *pOptions, p *env.Project) ([]string, error) {
+	vars := make(map[string]string)
+	for k, v := range p.Vars {
+		vars[k] = v
+	}
+	if o.pVarsFile != "" {
+		fv, err := feature.ReadVarsFile(o.pVarsFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fv {
+			vars[k] = v
+		}
+	}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, varEnvPrefix) {
+			spl := strings.SplitN(strings.TrimPrefix(kv, varEnvPrefix), "=", 2)
+			vars[spl[0]] = spl[1]
+		}
+	}
+	fv, err := feature.ParseVars(feature.SplitVars(o.pVar)...)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fv {
+		vars[k] = v
+	}
+	var ret []string
+	for k, v := range vars {
+		ret = append(ret, k+"="+v)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func varsFlags(o *pOptions, fs *flip.FlagSet) {
+	fs.StringVar(&o.pVar, "var", o.pVar, "Comma separated string list of name=value variables substituted for ${name} in documents read, a value keeping any comma not followed by a name=.")
+	fs.StringVar(&o.pVarsFile, "vars", o.pVarsFile, "A yaml or json file of variables, by name, substituted for ${name} in documents read.")
+}
+
 func populateVector(o *Options) (string, *data.Vector, error) {
 	d := newVector(o)
-	cp := data.NewStringsItem("constructor-plugin", o.files("constructor-plugin")...)
-	fp := data.NewStringsItem("feature-plugin", o.files("feature-plugin")...)
-	fs := data.NewStringsItem("features", o.files("features")...)
-	cs := data.NewStringsItem("components", o.files("components")...)
-	es := data.NewStringsItem("entities", o.files("entities")...)
-	d.Set(cp, fp, fs, cs, es)
-	d.SetStrings("groups", o.pGroup)
+	p := &env.Project{}
+	if o.pProject != "" {
+		if o.pInline || o.pStdin || o.pShare != "" {
+			return "", nil, ProjectInlineError()
+		}
+		var err error
+		if p, err = env.ReadProject(o.pProject); err != nil {
+			return "", nil, err
+		}
+	}
+	vars, err := requestVars(o.pOptions, p)
+	if err != nil {
+		return "", nil, err
+	}
+	d.Set(data.NewStringsItem("vars", vars...))
+	if o.pInline || o.pStdin || o.pShare != "" {
+		return populateInlineVector(o, d)
+	}
+	cp := data.NewStringsItem("constructor-plugin", append(p.ConstructorPlugins, o.files("constructor-plugin")...)...)
+	fp := data.NewStringsItem("feature-plugin", append(p.FeaturePlugins, o.files("feature-plugin")...)...)
+	fs := data.NewStringsItem("features", append(p.Features, o.files("features")...)...)
+	cs := data.NewStringsItem("components", append(p.Components, o.files("components")...)...)
+	es := data.NewStringsItem("entities", append(p.Entities, o.files("entities")...)...)
+	ps := data.NewStringsItem("patches", append(p.Patches, o.files("patches")...)...)
+	d.Set(cp, fp, fs, cs, es, ps)
+	if o.pGroup == "" {
+		d.Set(data.NewStringsItem("groups", p.Groups...))
+	} else {
+		d.SetStrings("groups", o.pGroup)
+	}
+	if o.pLoad == "" {
+		d.SetString("load", p.Load)
+	} else {
+		d.SetString("load", o.pLoad)
+	}
 	return "populate_from_files", d, nil
 }
 
@@ -507,17 +731,29 @@ func PopulateCommand() flip.Command {
 		fs.StringVar(&o.pGroup, "group", o.pGroup, "Comma separated string list of set tags to apply to all features read in with this instance.")
 		fs.StringVar(&o.pConstructorPlugin, "constructorPlugin", o.pConstructorPlugin, "Comma separated string list of directories containing Constructor plugins.")
 		fs.StringVar(&o.pFeaturePlugin, "featurePlugin", o.pFeaturePlugin, "Comma separated string list of directories containing Feature plugins.")
+		fs.BoolVar(&o.pInline, "inline", o.pInline, "Send the content of the provided files, rather than paths the server reads; files may not include others.")
+		fs.BoolVar(&o.pStdin, "stdin", o.pStdin, "Send a document of -kind read from standard input.")
+		fs.StringVar(&o.pKind, "kind", o.pKind, "The kind of document read with -stdin [features, components, entities, patches].")
+		fs.StringVar(&o.pPatch, "patch", o.pPatch, "Apply patches to existing definitions from files or directories, reverted by depopulating -group.")
+		fs.StringVar(&o.pShare, "share", o.pShare, "Comma separated string list of feature group share strings to send.")
+		fs.StringVar(&o.pLoad, "load", o.pLoad, "How anything already defined is loaded [error, skip, replace, merge-values], unless a document sets load itself.")
+		fs.StringVar(&o.pProject, "project", o.pProject, "Populate everything a countfloyd.yaml project file, or a directory holding one, lists, before any other files provided.")
+		varsFlags(o.pOptions, fs)
 		filesFlags(o, fs)
 		return fs
 	}(o)
 	return flip.NewCommand(
 		"",
 		"populate",
-		"populate a countfloyd server with features from provided files.",
+		"populate a countfloyd server with features from provided files, standard input or share strings.",
 		1,
 		false,
 		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
-			action, d, _ := populateVector(o)
+			action, d, err := populateVector(o)
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitUsageError
+			}
 			return c, connect(Sonnect, "data", action, d)
 		},
 		fs,
@@ -530,6 +766,52 @@ func depopulateVector(o *Options) (string, *data.Vector, error) {
 	return "depopulate", d, nil
 }
 
+func absPaths(in string) []string {
+	var ret []string
+	if in == "" {
+		return ret
+	}
+	for _, p := range strings.Split(in, ",") {
+		if ap, err := filepath.Abs(p); err == nil {
+			p = ap
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func watchVector(o *Options) (string, *data.Vector, error) {
+	d := newVector(o)
+	fs := data.NewStringsItem("features", absPaths(o.pFeature)...)
+	cs := data.NewStringsItem("components", absPaths(o.pComponent)...)
+	es := data.NewStringsItem("entities", absPaths(o.pEntity)...)
+	d.Set(fs, cs, es)
+	d.SetStrings("groups", o.pGroup)
+	return "watch", d, nil
+}
+
+func WatchCommand() flip.Command {
+	o := NewOptions()
+	fs := func(o *Options) *flip.FlagSet {
+		fs := flip.NewFlagSet("watch", flip.ContinueOnError)
+		fs.StringVar(&o.pGroup, "group", o.pGroup, "Comma separated string list of set tags to apply to all features read in with this instance.")
+		filesFlags(o, fs)
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"watch",
+		"populate a countfloyd server from files or directories, reloading them whenever they change; with no files lists watched files.",
+		1,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			action, d, _ := watchVector(o)
+			return c, connect(Sonnect, "data", action, d)
+		},
+		fs,
+	)
+}
+
 func DepopulateCommand() flip.Command {
 	o := NewOptions()
 	fs := func(o *Options) *flip.FlagSet {
@@ -574,6 +856,7 @@ func ApplyCommand() flip.Command {
 	fs := func(o *Options) *flip.FlagSet {
 		fs := flip.NewFlagSet("apply", flip.ContinueOnError)
 		applyFlags(o, fs)
+		localFlags(o, fs)
 		return fs
 	}(o)
 	return flip.NewCommand(
@@ -588,12 +871,75 @@ func ApplyCommand() flip.Command {
 				L.Print(err)
 				return c, flip.ExitUsageError
 			}
+			if o.aLocal {
+				return c, applyLocal(c, o, action, d)
+			}
 			return c, connect(Sonnect, "data", action, d)
 		},
 		fs,
 	)
 }
 
+type eOptions struct {
+	eCreate, eClone, eDrop, eFrom string
+}
+
+func envVector(o *eOptions) (string, *data.Vector, error) {
+	d := newVector(NewOptions())
+	var action string
+	var set []string
+	if o.eCreate != "" {
+		action = "env_create"
+		set = append(set, action)
+		d.Set(data.NewStringItem("env_name", o.eCreate))
+	}
+	if o.eClone != "" {
+		action = "env_clone"
+		set = append(set, action)
+		d.Set(data.NewStringItem("env_name", o.eClone), data.NewStringItem("env_from", o.eFrom))
+	}
+	if o.eDrop != "" {
+		action = "env_drop"
+		set = append(set, action)
+		d.Set(data.NewStringItem("env_name", o.eDrop))
+	}
+	switch len(set) {
+	case 0:
+		return "env_list", d, nil
+	case 1:
+		return action, d, nil
+	}
+	return "", nil, MoreThanAllowableError(set)
+}
+
+func EnvCommand() flip.Command {
+	o := &eOptions{eFrom: "default"}
+	fs := func(o *eOptions) *flip.FlagSet {
+		fs := flip.NewFlagSet("env", flip.ContinueOnError)
+		fs.StringVar(&o.eCreate, "create", o.eCreate, "Create an empty environment with this name.")
+		fs.StringVar(&o.eClone, "clone", o.eClone, "Create an environment with this name copying the -from environment.")
+		fs.StringVar(&o.eFrom, "from", o.eFrom, "The environment -clone copies.")
+		fs.StringVar(&o.eDrop, "drop", o.eDrop, "Drop the environment with this name.")
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"env",
+		"create, clone, drop or, with no flags, list server environments",
+		1,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			action, d, err := envVector(o)
+			if err != nil {
+				L.Print(err)
+				return c, flip.ExitUsageError
+			}
+			return c, connect(Sonnect, "system", action, d)
+		},
+		fs,
+	)
+}
+
 var retrievalKey = "store.retrieval.string"
 
 func newVector(o *Options) *data.Vector {
@@ -624,13 +970,23 @@ func init() {
 			StartCommand(),
 			StopCommand(),
 			QuitCommand(),
+			RestartCommand(),
 			StatusCommand(),
 			QueryCommand()).
 		SetGroup("action",
 			2,
 			PopulateCommand(),
 			DepopulateCommand(),
-			ApplyCommand())
+			WatchCommand(),
+			ApplyCommand(),
+			LintCommand(),
+			ExportCommand(),
+			ImportCommand()).
+		SetGroup("environment",
+			3,
+			EnvCommand(),
+			SnapshotCommand(),
+			CheckpointCommand())
 }
 
 func main() {
@@ -4,7 +4,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
 )
 
 var socketPath string = "/tmp/custom_countfloyd_socket_0"
@@ -13,7 +12,7 @@ var start, populate, status, query, stop *exec.Cmd
 
 func init() {
 	cd, _ := os.Getwd()
-	start = exec.Command("countfloyd", "-socket", socketPath, "-logFormatter", "raw", "start")
+	start = exec.Command("countfloyd", "-socket", socketPath, "-logFormatter", "raw", "start", "-wait")
 	start.Stdout = os.Stdout
 	populate = exec.Command("countfloyd", "-socket", socketPath, "-logFormatter", "raw", "populate", "-feature", filepath.Join(cd, "features.yaml"))
 	populate.Stdout = os.Stdout
@@ -25,9 +24,7 @@ func init() {
 }
 
 func main() {
-	start.Start()
-	duration := time.Second * 2
-	time.Sleep(duration)
+	start.Run()
 	populate.Start()
 	populate.Wait()
 	status.Start()
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+
+	"github.com/Laughs-In-Flowers/data"
+	"github.com/Laughs-In-Flowers/flip"
+)
+
+type snOptions struct {
+	snFile    string
+	snRestore bool
+}
+
+func snapshotVector(o *snOptions) (string, *data.Vector) {
+	d := newVector(NewOptions())
+	d.Set(data.NewStringItem("snapshot_file", o.snFile))
+	if o.snRestore {
+		return "restore", d
+	}
+	return "snapshot", d
+}
+
+// Snapshots every server environment to a file, or restores them from one.
+func SnapshotCommand() flip.Command {
+	o := &snOptions{}
+	fs := func(o *snOptions) *flip.FlagSet {
+		fs := flip.NewFlagSet("snapshot", flip.ContinueOnError)
+		fs.StringVar(&o.snFile, "file", o.snFile, "The snapshot file, by default that the server is configured with, and otherwise in the same directory.")
+		fs.BoolVar(&o.snRestore, "restore", o.snRestore, "Restore every environment from the snapshot file rather than writing it.")
+		return fs
+	}(o)
+	return flip.NewCommand(
+		"",
+		"snapshot",
+		"write every server environment to a snapshot file, or with -restore, replace them from one",
+		2,
+		false,
+		func(c context.Context, a []string) (context.Context, flip.ExitStatus) {
+			action, d := snapshotVector(o)
+			return c, connect(Sonnect, "system", action, d)
+		},
+		fs,
+	)
+}